@@ -0,0 +1,734 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"github.com/withmandala/go-log"
+)
+
+const (
+	BackendStarting = "starting"
+	BackendReady    = "ready"
+	BackendCrashed  = "crashed"
+	BackendDisabled = "disabled"
+)
+
+type backendSpec struct {
+	ID      string
+	Command string
+	// Prints the version, if the backend supports that
+	VersionCommand string
+	// The files selectLSForFile gives the backend, as a glob
+	Documents string
+}
+
+var defaultBackends = []backendSpec{
+	{ID: "json", Command: "vscode-json-languageserver --stdio", Documents: "**/*.json"},
+	{ID: "xml", Command: "lemminx", Documents: "**/*.{xml,doap}"},
+	{ID: "yaml", Command: "yaml-language-server --stdio", Documents: "**/*.{yaml,yml}"},
+	{ID: "ruff", Command: "ruff-lsp", VersionCommand: "ruff-lsp --version", Documents: "**/*.{py,pyi}"},
+	{ID: "rome", Command: "rome lsp-proxy", VersionCommand: "rome --version", Documents: "**/*.js"},
+	{ID: "toml", Command: "taplo lsp stdio", VersionCommand: "taplo --version", Documents: "**/*.{toml,toml.in}"},
+	{ID: "bash", Command: "bash-language-server start", VersionCommand: "bash-language-server --version"},
+}
+
+// A Backend is a started language server. A restart replaces it with a new
+// Backend, so that late messages of the old process can be told apart. The
+// fields are guarded by Server.mu.
+type Backend struct {
+	ID       string
+	Command  string
+	rpc      *JSONRPC
+	state    string
+	started  time.Time
+	restarts int
+	lastErr  error
+	stopped  bool
+	// Closed once initialize was answered or failed
+	ready    chan struct{}
+	initErr  error
+	deferred []encodeMessage
+	flushing bool
+	encoding string
+	syncKind protocol.TextDocumentSyncKind
+	// The names commands are advertised under, keyed by their own names
+	commands map[string]string
+	// The requests about documents registered with the editor for the backend
+	documentRequests []string
+	workspaceSymbols bool
+	// The files the backend wants to be told about, keyed by registration
+	watchers map[string][]fileWatcher
+}
+
+// The parameters of the editor's initialize, kept for backends started later.
+type initialization struct {
+	rootURI          *string
+	capabilities     protocol.ClientCapabilities
+	positionEncoding string
+}
+
+// A BackendFactory starts the backend id with command and returns the
+// connection to it.
+type BackendFactory func(id string, command string) (*JSONRPC, error)
+
+// processBackend returns a BackendFactory starting backends as processes.
+// Their stderr is passed to stderr line by line.
+func processBackend(stderr func(id string, line string)) BackendFactory {
+	return func(id string, command string) (*JSONRPC, error) {
+		process, err := CreateProcessFromCommand(command, func(line string) {
+			stderr(id, line)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return jsonrpcFromProcessIO(process), nil
+	}
+}
+
+// backendCommandLocked returns the command a backend is started with.
+func (s *Server) backendCommandLocked(id string) string {
+	if override, ok := s.options.Commands[id]; ok {
+		return override
+	}
+
+	for _, spec := range defaultBackends {
+		if spec.ID == id {
+			return spec.Command
+		}
+	}
+
+	return ""
+}
+
+// startBackend starts a backend. A backend that fails to start is logged and
+// marked as crashed, so that the other languages keep working.
+func (s *Server) startBackend(id string, restarts int) *Backend {
+	s.mu.RLock()
+	command := s.backendCommandLocked(id)
+	factory := s.options.Backends
+	s.mu.RUnlock()
+
+	if factory == nil {
+		factory = processBackend(func(id string, line string) {
+			s.backendLogger(id).Infof("(%v) %s", id, line)
+		})
+	}
+
+	backend := &Backend{
+		ID:       id,
+		Command:  command,
+		state:    BackendStarting,
+		started:  time.Now(),
+		restarts: restarts,
+		ready:    make(chan struct{}),
+		encoding: PositionEncodingUTF16,
+	}
+
+	rpc, err := factory(id, command)
+
+	s.mu.Lock()
+	s.backends[id] = backend
+
+	if err != nil {
+		backend.state = BackendCrashed
+		backend.lastErr = fmt.Errorf("unable to start %s: %w", command, err)
+		backend.initErr = backend.lastErr
+		close(backend.ready)
+		s.mu.Unlock()
+		s.logger.Errorf("(%v) %s", id, backend.lastErr)
+
+		return backend
+	}
+
+	backend.rpc = rpc
+
+	if s.options.MaxMessageSize > 0 {
+		rpc.MaxMessageSize = s.options.MaxMessageSize
+	}
+	s.mu.Unlock()
+
+	rpc.Trace(s.options.Tracer, id)
+
+	go s.runLS(backend)
+
+	return backend
+}
+
+// backendLogger returns the logger for everything a backend logs itself.
+func (s *Server) backendLogger(id string) *log.Logger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if logger, ok := s.backendLoggers[id]; ok {
+		return logger
+	}
+
+	level := LogLevelInfo
+	if s.editorConfig.LogLevel != "" {
+		level, _ = parseLogLevel(s.editorConfig.LogLevel)
+	}
+
+	if configured, ok := s.options.BackendLogLevels[id]; ok {
+		level = configured
+	}
+
+	// Opened once, loggers are recreated when the configuration is reloaded
+	out, ok := s.logOutputs[id]
+	if !ok {
+		var base log.FdWriter = os.Stderr
+		if s.options.LogOutput != nil {
+			base = s.options.LogOutput
+		}
+
+		out = logOutput(base, s.options.LogDirectory, id)
+		s.logOutputs[id] = out
+	}
+
+	logger, _ := newLogger(out, level)
+	s.backendLoggers[id] = logger
+
+	return logger
+}
+
+func (s *Server) backend(id string) (*JSONRPC, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	backend, ok := s.backends[id]
+	if !ok {
+		return nil, fmt.Errorf("language server %s is not running", id)
+	}
+
+	if backend.state == BackendCrashed {
+		return nil, backend.lastErr
+	}
+
+	return backend.rpc, nil
+}
+
+func (s *Server) sendToBackend(id string, message interface{}) error {
+	rpc, err := s.backend(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("unable to encode message for %s: %w", id, err)
+	}
+
+	if err := rpc.SendMessage(data); err != nil {
+		return fmt.Errorf("unable to send message to %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// broadcast forwards a notification of the editor to all backends.
+func (s *Server) broadcast(request map[string]interface{}) {
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.backends))
+
+	for id := range s.backends {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if err := s.redirectNotification(id, request); err != nil {
+			s.logger.Warnf("Unable to forward %v to %s: %s", request["method"], id, err)
+		}
+	}
+}
+
+func (s *Server) displayName(id string) string {
+	s.mu.RLock()
+	command := s.backendCommandLocked(id)
+	if backend, ok := s.backends[id]; ok {
+		command = backend.Command
+	}
+	s.mu.RUnlock()
+
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return fields[0]
+	}
+
+	return id
+}
+
+// backendSyncKind returns how a backend wants to be told about changes. Until
+// it is initialized, the whole document is sent, which is right for every kind.
+func (s *Server) backendSyncKind(id string) protocol.TextDocumentSyncKind {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	backend, ok := s.backends[id]
+	if !ok || backend.state != BackendReady {
+		return protocol.TextDocumentSyncKindFull
+	}
+
+	return backend.syncKind
+}
+
+func isDone(ready chan struct{}) bool {
+	select {
+	case <-ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// An encodeMessage builds a message for the position encoding of a backend.
+type encodeMessage func(encoding string) []byte
+
+// forward sends a message from the editor to a backend. Until the backend is
+// initialized, messages are deferred and then sent in their original order.
+func (s *Server) forward(id string, data []byte) error {
+	return s.forwardEncoded(id, func(string) []byte {
+		return data
+	})
+}
+
+// forwardEncoded is forward for messages with positions, which can only be
+// converted once the backend negotiated its position encoding.
+func (s *Server) forwardEncoded(id string, encode encodeMessage) error {
+	s.mu.Lock()
+	backend, ok := s.backends[id]
+
+	if !ok {
+		s.mu.Unlock()
+
+		return fmt.Errorf("language server %s is not running", id)
+	}
+
+	if backend.state == BackendCrashed {
+		err := backend.lastErr
+		s.mu.Unlock()
+
+		return err
+	}
+
+	if !isDone(backend.ready) || backend.flushing {
+		backend.deferred = append(backend.deferred, encode)
+		s.mu.Unlock()
+
+		return nil
+	}
+
+	rpc := backend.rpc
+	encoding := backend.encoding
+	s.mu.Unlock()
+
+	if err := rpc.SendMessage(encode(encoding)); err != nil {
+		return fmt.Errorf("unable to send message to %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// markReady ends the initialization of a backend. On success, deferred
+// messages are sent, otherwise deferred requests are answered with an error.
+func (s *Server) markReady(backend *Backend, initErr error) {
+	s.mu.Lock()
+	if isDone(backend.ready) {
+		s.mu.Unlock()
+
+		return
+	}
+
+	backend.initErr = initErr
+	backend.flushing = initErr == nil
+
+	if initErr != nil {
+		backend.state = BackendCrashed
+		backend.lastErr = initErr
+	} else {
+		backend.state = BackendReady
+	}
+
+	close(backend.ready)
+	s.mu.Unlock()
+
+	if initErr != nil {
+		s.logger.Errorf("(%v) %s", backend.ID, initErr)
+		s.failDeferred(backend, initErr)
+
+		return
+	}
+
+	for {
+		s.mu.Lock()
+		batch := backend.deferred
+		backend.deferred = nil
+		encoding := backend.encoding
+
+		if len(batch) == 0 {
+			backend.flushing = false
+			s.mu.Unlock()
+
+			return
+		}
+		s.mu.Unlock()
+
+		s.logger.Infof("(%v) Sending %d deferred messages", backend.ID, len(batch))
+
+		for _, encode := range batch {
+			if err := backend.rpc.SendMessage(encode(encoding)); err != nil {
+				s.logger.Warnf("(%v) Unable to send deferred message: %s", backend.ID, err)
+			}
+		}
+	}
+}
+
+func (s *Server) failDeferred(backend *Backend, initErr error) {
+	s.mu.Lock()
+	batch := backend.deferred
+	backend.deferred = nil
+	encoding := backend.encoding
+	s.mu.Unlock()
+
+	factor, err := str2int(backend.ID)
+	if err != nil {
+		return
+	}
+
+	for _, encode := range batch {
+		var message map[string]interface{}
+		if err := json.Unmarshal(encode(encoding), &message); err != nil {
+			continue
+		}
+
+		seq, err := ExtractIntValue(message["id"])
+		if err != nil {
+			continue // Notifications are dropped
+		}
+
+		s.mu.Lock()
+		pending, ok := s.pendingRequests[seq]
+		delete(s.pendingRequests, seq)
+		s.mu.Unlock()
+
+		if ok {
+			s.failRequest(seq-factor*LanguageServerFactor, pending, initErr)
+		}
+	}
+}
+
+// failPending answers all requests a backend didn't answer yet with an error.
+func (s *Server) failPending(id string, reason error) {
+	factor, err := str2int(id)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	failed := make(map[int]*pendingRequest)
+
+	for seq, pending := range s.pendingRequests {
+		if pending.backend == id {
+			failed[seq] = pending
+			delete(s.pendingRequests, seq)
+		}
+	}
+	s.mu.Unlock()
+
+	for seq, pending := range failed {
+		s.failRequest(seq-factor*LanguageServerFactor, pending, reason)
+	}
+}
+
+// failRequest answers a request the backend won't answer. A request sent to
+// several backends is answered once the others did.
+func (s *Server) failRequest(seq int, pending *pendingRequest, reason error) {
+	if pending.fanOut != nil {
+		s.collect(pending.fanOut, nil)
+
+		return
+	}
+
+	s.respondWithError(seq, newResponseError(RequestFailed, "%s", reason))
+}
+
+// backendExited is called once the connection to a backend is gone.
+func (s *Server) backendExited(backend *Backend, reason error) {
+	s.mu.Lock()
+	stopped := backend.stopped
+
+	if !stopped {
+		backend.state = BackendCrashed
+		backend.lastErr = reason
+	}
+	s.mu.Unlock()
+
+	if stopped {
+		return
+	}
+
+	s.logger.Errorf("(%v) %s", backend.ID, reason)
+	s.markReady(backend, reason)
+	s.failPending(backend.ID, reason)
+}
+
+// stopBackend kills a backend that is going to be replaced or disabled.
+func (s *Server) stopBackend(backend *Backend, reason error) {
+	s.mu.Lock()
+	backend.stopped = true
+	s.mu.Unlock()
+
+	s.markReady(backend, reason)
+	s.unregisterCommands(backend)
+	s.unregisterDocumentRequests(backend)
+
+	if backend.rpc != nil {
+		if process := backend.rpc.Process(); process != nil {
+			if err := process.Kill(); err != nil {
+				s.logger.Warnf("(%v) Unable to kill %s: %s", backend.ID, backend.Command, err)
+			}
+		}
+
+		backend.rpc.Close()
+	}
+
+	s.failPending(backend.ID, reason)
+}
+
+// restartBackend replaces a backend with a new process. Open documents are
+// opened again, so the new process has the same view of the workspace.
+func (s *Server) restartBackend(id string) error {
+	if _, err := str2int(id); err != nil {
+		return newResponseError(InvalidParams, "%s", err)
+	}
+
+	s.mu.RLock()
+	old := s.backends[id]
+	disabled := s.isDisabledLocked(id)
+	initialized := s.initialization != nil
+	s.mu.RUnlock()
+
+	if disabled {
+		return newResponseError(InvalidParams, "%s is disabled", id)
+	}
+
+	restarts := 0
+
+	if old != nil {
+		restarts = old.restarts + 1
+		s.stopBackend(old, fmt.Errorf("%s is restarting", s.displayName(id)))
+	}
+
+	s.logger.Infof("(%v) Restarting", id)
+	backend := s.startBackend(id, restarts)
+
+	if initialized {
+		s.initializeBackend(backend)
+		s.replayDocuments(id)
+		s.updateConfigs()
+	}
+
+	return nil
+}
+
+// backendCommands returns the commands backends are started with.
+func (s *Server) backendCommands() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	commands := make(map[string]string, len(defaultBackends))
+	for _, spec := range defaultBackends {
+		commands[spec.ID] = s.backendCommandLocked(spec.ID)
+	}
+
+	return commands
+}
+
+// syncBackends stops backends that were disabled and starts those that were
+// enabled. Backends whose command differs from the one in commands are
+// restarted.
+func (s *Server) syncBackends(commands map[string]string) error {
+	for _, spec := range defaultBackends {
+		s.mu.RLock()
+		backend, running := s.backends[spec.ID]
+		disabled := s.isDisabledLocked(spec.ID)
+		changed := s.backendCommandLocked(spec.ID) != commands[spec.ID]
+		s.mu.RUnlock()
+
+		switch {
+		case disabled && running:
+			s.logger.Infof("(%v) Disabled", spec.ID)
+			s.stopBackend(backend, fmt.Errorf("%s was disabled", s.displayName(spec.ID)))
+			s.mu.Lock()
+			delete(s.backends, spec.ID)
+			s.mu.Unlock()
+		case !disabled && (!running || changed):
+			if err := s.restartBackend(spec.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) replayDocuments(id string) {
+	messages := make([]map[string]interface{}, 0)
+
+	for _, document := range s.documents.All() {
+		if owner, err := s.selectLSForFile(document.URI, "", true); err != nil || owner != id {
+			continue
+		}
+
+		messages = append(messages, makeNotification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"uri":        document.URI,
+				"languageId": document.LanguageID,
+				"version":    document.Version,
+				"text":       document.Text,
+			},
+		}))
+	}
+
+	if id == "bash" {
+		s.mu.RLock()
+		for _, document := range s.virtualDocuments {
+			messages = append(messages, makeNotification("textDocument/didOpen", map[string]interface{}{
+				"textDocument": map[string]interface{}{
+					"uri":        document.URI,
+					"languageId": "shellscript",
+					"version":    document.Version,
+					"text":       document.Snippet.text,
+				},
+			}))
+		}
+		s.mu.RUnlock()
+	}
+
+	for _, message := range messages {
+		if err := s.redirectNotification(id, message); err != nil {
+			s.logger.Warnf("(%v) Unable to open documents again: %s", id, err)
+
+			return
+		}
+	}
+}
+
+// InitializeAll starts the initialization of all backends without waiting for
+// it. Messages for a backend are deferred until it is ready.
+func (s *Server) InitializeAll(rootURI *string, clientCaps protocol.ClientCapabilities, positionEncoding string) {
+	s.mu.Lock()
+	s.initialization = &initialization{
+		rootURI:          rootURI,
+		capabilities:     clientCaps,
+		positionEncoding: positionEncoding,
+	}
+
+	backends := make([]*Backend, 0, len(s.backends))
+	for _, backend := range s.backends {
+		backends = append(backends, backend)
+	}
+	s.mu.Unlock()
+
+	for _, backend := range backends {
+		s.initializeBackend(backend)
+	}
+}
+
+// awaitBackends waits until every backend is ready or failed to initialize.
+func (s *Server) awaitBackends() {
+	s.mu.RLock()
+	ready := make([]chan struct{}, 0, len(s.backends))
+
+	for _, backend := range s.backends {
+		ready = append(ready, backend.ready)
+	}
+	s.mu.RUnlock()
+
+	for _, ready := range ready {
+		<-ready
+	}
+}
+
+func (s *Server) initializeBackend(backend *Backend) {
+	s.mu.RLock()
+	init := s.initialization
+	traceValue := s.trace
+	folders := s.currentWorkspaceFoldersLocked()
+	failed := isDone(backend.ready)
+	s.mu.RUnlock()
+
+	if init == nil || failed {
+		return
+	}
+
+	version := "0.0.1"
+	pid := int32(syscall.Getpid())
+	call := makeRequest(1, "initialize", protocol.InitializeParams{
+		ProcessID:        &pid,
+		RootURI:          init.rootURI,
+		WorkspaceFolders: folders,
+		Trace:            &traceValue,
+		ClientInfo: &struct {
+			Name    string  `json:"name"`
+			Version *string `json:"version,omitempty"`
+		}{Name: "proxy-ls", Version: &version},
+		Capabilities: init.capabilities,
+		InitializationOptions: map[string]interface{}{
+			"handledSchemaProtocols": []string{"file", "http", "https"},
+			"configurationSection":   "evenBetterToml",
+			"provideFormatter":       true,
+			"settings": map[string]interface{}{
+				"xml":     s.section("xml", ""),
+				"yaml":    s.section("yaml", ""),
+				"pyright": s.section("pyright", ""),
+				"python":  s.section("python", ""),
+			},
+			"globalSettings": s.section("ruff", ""),
+		},
+	})
+	data, _ := json.Marshal(adjustClientCapabilities(call, init.positionEncoding))
+
+	if err := backend.rpc.SendMessage(data); err != nil {
+		s.markReady(backend, fmt.Errorf("unable to send initialize to %s: %w", s.displayName(backend.ID), err))
+
+		return
+	}
+
+	go s.awaitInitialization(backend)
+}
+
+func (s *Server) awaitInitialization(backend *Backend) {
+	name := s.displayName(backend.ID)
+	token := fmt.Sprintf("proxy-ls/initialize/%s/%d", backend.ID, backend.restarts)
+	s.beginProgress(token, fmt.Sprintf("Starting %s…", name))
+
+	timeout := s.options.InitializeTimeout
+	if timeout == 0 {
+		timeout = InitializeTimeout
+	}
+
+	select {
+	case <-backend.ready:
+	case <-time.After(timeout):
+		s.stopBackend(backend, fmt.Errorf("%s did not initialize within %s", name, timeout))
+	}
+
+	s.mu.RLock()
+	initErr := backend.initErr
+	s.mu.RUnlock()
+
+	if initErr != nil {
+		s.endProgress(token, initErr.Error())
+	} else {
+		s.endProgress(token, fmt.Sprintf("%s is ready", name))
+	}
+}
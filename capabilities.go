@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// adjustClientCapabilities rewrites the capabilities of the editor in an
+// initialize request into those proxy-ls offers to a backend.
+func adjustClientCapabilities(call map[string]interface{}, positionEncoding string) map[string]interface{} {
+	var params map[string]interface{}
+
+	marshalledParams, _ := json.Marshal(call["params"])
+	if err := json.Unmarshal(marshalledParams, &params); err != nil {
+		return call
+	}
+
+	capabilities := capabilityObject(params, "capabilities")
+	capabilityObject(capabilities, "workspace")["configuration"] = true
+	capabilityObject(capabilities, "workspace")["workspaceFolders"] = true
+	// proxy-ls watches files for backends, with or without the help of the editor
+	capabilityObject(capabilityObject(capabilities, "workspace"), "didChangeWatchedFiles")["dynamicRegistration"] = true
+	capabilityObject(capabilityObject(capabilities, "workspace"), "didChangeWatchedFiles")["relativePatternSupport"] = true
+	capabilityObject(capabilityObject(capabilities, "textDocument"), "rangeFormatting")["dynamicRegistration"] = true
+	capabilityObject(capabilities, "general")["positionEncodings"] = preferredPositionEncodings(positionEncoding)
+	call["params"] = params
+
+	return call
+}
+
+func capabilityObject(parent map[string]interface{}, key string) map[string]interface{} {
+	object, ok := parent[key].(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+		parent[key] = object
+	}
+
+	return object
+}
+
+// Requests about documents that editors only send to servers declaring them.
+// proxy-ls registers them for the documents of the backends declaring them,
+// as advertising them for all documents would have editors wait for answers
+// of backends that can't give any.
+var backendDocumentRequests = []string{"textDocument/onTypeFormatting", "textDocument/willSaveWaitUntil"}
+
+func documentRequestRegistrationID(id string, method string) string {
+	return fmt.Sprintf("proxy-ls/%s/%s", method, id)
+}
+
+// declaredDocumentRequests returns the registration options of the requests
+// of backendDocumentRequests a backend declares in its capabilities.
+func declaredDocumentRequests(capabilities map[string]interface{}) map[string]map[string]interface{} {
+	declared := make(map[string]map[string]interface{}, len(backendDocumentRequests))
+
+	if options, ok := capabilities["documentOnTypeFormattingProvider"].(map[string]interface{}); ok {
+		if trigger, ok := options["firstTriggerCharacter"].(string); ok && trigger != "" {
+			declared["textDocument/onTypeFormatting"] = map[string]interface{}{
+				"firstTriggerCharacter": trigger,
+				"moreTriggerCharacter":  options["moreTriggerCharacter"],
+			}
+		}
+	}
+
+	if sync, ok := capabilities["textDocumentSync"].(map[string]interface{}); ok && sync["willSaveWaitUntil"] == true {
+		declared["textDocument/willSaveWaitUntil"] = map[string]interface{}{}
+	}
+
+	return declared
+}
+
+func documentSelector(id string) []interface{} {
+	for _, spec := range defaultBackends {
+		if spec.ID == id && spec.Documents != "" {
+			return []interface{}{map[string]interface{}{"pattern": spec.Documents}}
+		}
+	}
+
+	return nil
+}
+
+// registerDocumentRequests registers the requests of backendDocumentRequests
+// a backend declares for its documents, if the editor supports that.
+func (s *Server) registerDocumentRequests(backend *Backend, declared map[string]map[string]interface{}) {
+	selector := documentSelector(backend.ID)
+	registrations := make([]interface{}, 0, len(declared))
+
+	s.mu.Lock()
+	for _, method := range backendDocumentRequests {
+		options, ok := declared[method]
+		if !ok || selector == nil || !s.dynamicDocumentRequests[method] {
+			continue
+		}
+
+		options["documentSelector"] = selector
+		registrations = append(registrations, map[string]interface{}{
+			"id":              documentRequestRegistrationID(backend.ID, method),
+			"method":          method,
+			"registerOptions": options,
+		})
+		backend.documentRequests = append(backend.documentRequests, method)
+	}
+	s.mu.Unlock()
+
+	if len(registrations) == 0 {
+		return
+	}
+
+	err := s.requestEditor("client/registerCapability", map[string]interface{}{"registrations": registrations},
+		func(_ interface{}, err interface{}) {
+			if err != nil {
+				s.logger.Warnf("(%v) Unable to register requests: %v", backend.ID, err)
+			}
+		})
+	if err != nil {
+		s.logger.Warnf("(%v) Unable to register requests: %s", backend.ID, err)
+	}
+}
+
+// unregisterDocumentRequests withdraws the registrations of a stopped backend.
+func (s *Server) unregisterDocumentRequests(backend *Backend) {
+	s.mu.Lock()
+	methods := backend.documentRequests
+	backend.documentRequests = nil
+	s.mu.Unlock()
+
+	if len(methods) == 0 {
+		return
+	}
+
+	unregistrations := make([]interface{}, 0, len(methods))
+
+	for _, method := range methods {
+		unregistrations = append(unregistrations, map[string]interface{}{
+			"id":     documentRequestRegistrationID(backend.ID, method),
+			"method": method,
+		})
+	}
+
+	// Sic, the specification misspells it
+	err := s.requestEditor("client/unregisterCapability", map[string]interface{}{"unregisterations": unregistrations},
+		func(_ interface{}, _ interface{}) {})
+	if err != nil {
+		s.logger.Warnf("(%v) Unable to unregister requests: %s", backend.ID, err)
+	}
+}
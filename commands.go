@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The commands of backends are advertised under their own names. If proxy-ls
+// or several backends use a name, every backend advertises the command as
+// <backend>.<command> instead, so that names don't depend on the order
+// backends are initialized in.
+
+func commandRegistrationID(id string) string {
+	return fmt.Sprintf("proxy-ls/executeCommand/%s", id)
+}
+
+// assignCommandNamesLocked names the commands of all running backends and
+// returns the backends whose names changed.
+func (s *Server) assignCommandNamesLocked(backends []*Backend) []*Backend {
+	declared := make(map[string]int)
+
+	for _, backend := range backends {
+		for command := range backend.commands {
+			declared[command]++
+		}
+	}
+
+	for name, owner := range s.commandOwners {
+		if owner != "" {
+			delete(s.commandOwners, name)
+		}
+	}
+
+	var changed []*Backend
+
+	for _, backend := range backends {
+		renamed := false
+
+		for command, exposed := range backend.commands {
+			name := command
+			if _, proxy := s.commandOwners[name]; proxy || declared[command] > 1 {
+				name = fmt.Sprintf("%s.%s", backend.ID, command)
+			}
+
+			if name != exposed {
+				backend.commands[command] = name
+				renamed = true
+			}
+		}
+
+		if renamed {
+			changed = append(changed, backend)
+		}
+	}
+
+	for _, backend := range backends {
+		for _, name := range backend.commands {
+			s.commandOwners[name] = backend.ID
+		}
+	}
+
+	return changed
+}
+
+// commandBackendsLocked returns the running backends that declare commands.
+func (s *Server) commandBackendsLocked() []*Backend {
+	backends := make([]*Backend, 0, len(s.backends))
+
+	for _, spec := range defaultBackends {
+		if backend, ok := s.backends[spec.ID]; ok && !backend.stopped && len(backend.commands) > 0 {
+			backends = append(backends, backend)
+		}
+	}
+
+	return backends
+}
+
+// staticCommands returns the commands advertised in the initialize result.
+// Editors that register commands dynamically learn those of backends later.
+func (s *Server) staticCommands(dynamic bool) []string {
+	commands := append([]string{}, proxyCommands...)
+	if dynamic {
+		return commands
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, backend := range s.commandBackendsLocked() {
+		commands = append(commands, exposedCommands(backend)...)
+	}
+
+	return commands
+}
+
+func exposedCommands(backend *Backend) []string {
+	exposed := make([]string, 0, len(backend.commands))
+
+	for _, name := range backend.commands {
+		exposed = append(exposed, name)
+	}
+
+	sort.Strings(exposed)
+
+	return exposed
+}
+
+// registerCommands assigns the commands of a backend the names they are
+// advertised under and registers them with the editor, if it supports that.
+func (s *Server) registerCommands(backend *Backend, commands []string) {
+	s.mu.Lock()
+	backend.commands = make(map[string]string, len(commands))
+
+	for _, command := range commands {
+		backend.commands[command] = ""
+	}
+
+	changed := s.assignCommandNamesLocked(s.commandBackendsLocked())
+	registrations := make(map[*Backend][]string, len(changed))
+
+	for _, other := range changed {
+		registrations[other] = exposedCommands(other)
+	}
+
+	dynamic := s.dynamicCommands
+	s.mu.Unlock()
+
+	if !dynamic {
+		return
+	}
+
+	for _, other := range changed {
+		if other != backend {
+			s.sendCommandUnregistration(other.ID)
+		}
+
+		s.sendCommandRegistration(other.ID, registrations[other])
+	}
+}
+
+// unregisterCommands releases the names of the commands of a stopped backend.
+func (s *Server) unregisterCommands(backend *Backend) {
+	s.mu.Lock()
+	registered := len(backend.commands) > 0
+	backend.commands = nil
+
+	var changed []*Backend
+
+	registrations := make(map[*Backend][]string)
+
+	if registered {
+		changed = s.assignCommandNamesLocked(s.commandBackendsLocked())
+		for _, other := range changed {
+			registrations[other] = exposedCommands(other)
+		}
+	}
+
+	dynamic := s.dynamicCommands
+	s.mu.Unlock()
+
+	if !registered || !dynamic {
+		return
+	}
+
+	s.sendCommandUnregistration(backend.ID)
+
+	for _, other := range changed {
+		s.sendCommandUnregistration(other.ID)
+		s.sendCommandRegistration(other.ID, registrations[other])
+	}
+}
+
+func (s *Server) sendCommandRegistration(id string, commands []string) {
+	params := map[string]interface{}{
+		"registrations": []interface{}{
+			map[string]interface{}{
+				"id":              commandRegistrationID(id),
+				"method":          "workspace/executeCommand",
+				"registerOptions": map[string]interface{}{"commands": commands},
+			},
+		},
+	}
+
+	err := s.requestEditor("client/registerCapability", params, func(_ interface{}, err interface{}) {
+		if err != nil {
+			s.logger.Warnf("(%v) Unable to register commands: %v", id, err)
+		}
+	})
+	if err != nil {
+		s.logger.Warnf("(%v) Unable to register commands: %s", id, err)
+	}
+}
+
+func (s *Server) sendCommandUnregistration(id string) {
+	params := map[string]interface{}{
+		// Sic, the specification misspells it
+		"unregisterations": []interface{}{
+			map[string]interface{}{
+				"id":     commandRegistrationID(id),
+				"method": "workspace/executeCommand",
+			},
+		},
+	}
+
+	err := s.requestEditor("client/unregisterCapability", params, func(_ interface{}, _ interface{}) {})
+	if err != nil {
+		s.logger.Warnf("(%v) Unable to unregister commands: %s", id, err)
+	}
+}
+
+// executeCommand runs a command of proxy-ls or sends it to the backend that
+// advertised it.
+func (s *Server) executeCommand(request map[string]interface{}) error {
+	var command struct {
+		Command   string        `json:"command"`
+		Arguments []interface{} `json:"arguments"`
+	}
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &command); err != nil {
+		return newResponseError(InvalidParams, "Invalid workspace/executeCommand params: %s", err)
+	}
+
+	s.mu.RLock()
+	owner, ok := s.commandOwners[command.Command]
+	name := command.Command
+
+	if backend, running := s.backends[owner]; ok && running {
+		for original, exposed := range backend.commands {
+			if exposed == command.Command {
+				name = original
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		return newResponseError(InvalidParams, "Unknown command %s", command.Command)
+	}
+
+	if owner == "" {
+		result, err := s.runProxyCommand(command.Command, command.Arguments)
+		if err != nil {
+			return err
+		}
+
+		return s.sendToEditor(makeResponse(request["id"], result))
+	}
+
+	var params map[string]interface{}
+	_ = json.Unmarshal(marshalledParams, &params)
+	params["command"] = name
+
+	return s.redirectRequest(owner, makeRequest(request["id"], "workspace/executeCommand", params), nil)
+}
+
+// exposeCommands renames the commands in a result of a backend to the names
+// they are advertised under.
+func (s *Server) exposeCommands(id string, value interface{}) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	backend, ok := s.backends[id]
+	if !ok {
+		return value
+	}
+
+	renamed := false
+
+	for original, exposed := range backend.commands {
+		if original != exposed {
+			renamed = true
+		}
+	}
+
+	if !renamed {
+		return value
+	}
+
+	return renameCommands(value, backend.commands)
+}
+
+func renameCommands(value interface{}, names map[string]string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		_, hasTitle := value["title"].(string)
+		if command, ok := value["command"].(string); ok && hasTitle {
+			if name, ok := names[command]; ok {
+				value["command"] = name
+			}
+		}
+
+		for key, child := range value {
+			value[key] = renameCommands(child, names)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = renameCommands(child, names)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config is the user configuration, by default read from
+// $XDG_CONFIG_HOME/proxy-ls/config.json. Command-line flags take precedence.
+type Config struct {
+	LogLevel  string `json:"logLevel"`
+	LogFile   string `json:"logFile"`
+	TraceFile string `json:"traceFile"`
+	// The size in bytes of the largest message accepted on any connection
+	MaxMessageSize int      `json:"maxMessageSize"`
+	Disabled       []string `json:"disabled"`
+	// Enables backends disabled by a configuration read earlier
+	Enabled  []string                 `json:"enabled"`
+	Backends map[string]BackendConfig `json:"backends"`
+	// Merged into the settings backends get, keyed by section
+	Settings map[string]interface{} `json:"settings"`
+	// Glob patterns of the JSON and YAML files each schema applies to
+	Schemas map[string][]string `json:"schemas"`
+	// Settings for the documents in a directory, keyed by directory
+	Scopes map[string]map[string]interface{} `json:"scopes"`
+	// Whether each backend formats its documents, by default all do
+	Formatters map[string]bool `json:"formatters"`
+}
+
+type BackendConfig struct {
+	Command  string `json:"command"`
+	LogLevel string `json:"logLevel"`
+	// The lowest severity of messages shown in the editor
+	MessageLevel string `json:"messageLevel"`
+}
+
+func xdgDirectory(variable string, fallback string) string {
+	if directory := os.Getenv(variable); filepath.IsAbs(directory) {
+		return directory
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, fallback)
+}
+
+func defaultConfigPath() string {
+	directory := xdgDirectory("XDG_CONFIG_HOME", ".config")
+	if directory == "" {
+		return ""
+	}
+
+	return filepath.Join(directory, "proxy-ls", "config.json")
+}
+
+// LoadConfig reads the configuration at path. A missing file is only an error
+// if it was explicitly asked for.
+func LoadConfig(path string, required bool) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return config, nil
+	} else if err != nil {
+		return config, fmt.Errorf("LoadConfig(): %w", err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("LoadConfig(): %s: %w", path, err)
+	}
+
+	for id := range config.Backends {
+		if _, err := str2int(id); err != nil {
+			return config, fmt.Errorf("LoadConfig(): %s: %w", path, err)
+		}
+	}
+
+	return config, nil
+}
@@ -1,11 +1,20 @@
 package main
 
+import "sort"
+
+const (
+	FlatpakManifestSchema = "https://raw.githubusercontent.com/flatpak/flatpak-builder/main/data/flatpak-manifest.schema.json"
+	GSchemaDTD            = "https://gitlab.gnome.org/GNOME/glib/-/raw/HEAD/gio/gschema.dtd"
+	GResourceDTD          = "https://gitlab.gnome.org/GNOME/glib/-/raw/HEAD/gio/gresource.dtd"
+	SchemaStoreCatalog    = "https://www.schemastore.org/api/json/catalog.json"
+)
+
 func xmlConfig(schemas [](map[string]interface{})) map[string]interface{} {
 	return map[string]interface{}{
 		"fileAssociations": schemas,
+		// Sent as window/logMessage and written to the log of the backend
 		"logs": map[string]interface{}{
 			"client": true,
-			"file":   "/tmp/lemminx.log",
 		},
 		"trace": map[string]interface{}{
 			"server": "verbose",
@@ -30,9 +39,123 @@ func yamlConfig(yamlSchemas map[string]interface{}) map[string]interface{} {
 		},
 		"schemaStore": map[string]interface{}{
 			"enable": true,
-			"url":    "https://www.schemastore.org/api/json/catalog.json",
+			"url":    SchemaStoreCatalog,
 		},
 		"validate": true,
 		"schemas":  yamlSchemas,
 	}
 }
+
+func tomlConfig(associations map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"schema": map[string]interface{}{
+			"enabled":      true,
+			"associations": associations,
+			"catalogs":     []string{SchemaStoreCatalog},
+			"links":        true,
+		},
+		"taplo": map[string]interface{}{
+			"configFile": map[string]interface{}{
+				"enabled": true,
+			},
+		},
+	}
+}
+
+// Keys are regular expressions that taplo matches against the document URI.
+func tomlSchemas() map[string]interface{} {
+	return map[string]interface{}{
+		`/Cargo\.toml$`:     "https://json.schemastore.org/cargo.json",
+		`/pyproject\.toml$`: "https://json.schemastore.org/pyproject.json",
+		`/\.?ruff\.toml$`:   "https://json.schemastore.org/ruff.json",
+	}
+}
+
+func bashConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"shellcheckPath":               "shellcheck",
+		"enableSourceErrorDiagnostics": false,
+	}
+}
+
+// referencedSchemas lists every schema the configurations refer to.
+func referencedSchemas() []string {
+	schemas := []string{FlatpakManifestSchema, GSchemaDTD, GResourceDTD, SchemaStoreCatalog}
+
+	for _, schema := range tomlSchemas() {
+		if schema, ok := schema.(string); ok {
+			schemas = append(schemas, schema)
+		}
+	}
+
+	sort.Strings(schemas)
+
+	return schemas
+}
+
+// The sections of the settings each backend reads.
+var backendSections = map[string][]string{
+	"json": {"json", "http"},
+	"xml":  {"xml"},
+	"yaml": {"yaml", "[yaml]", "editor", "files"},
+	"ruff": {"ruff", "python", "pyright"},
+	"rome": {"rome"},
+	"toml": {"evenBetterToml"},
+	"bash": {"bashIde"},
+}
+
+// defaultSettings returns the built-in settings, keyed by section.
+func defaultSettings() map[string]interface{} {
+	xml := xmlConfig(make([](map[string]interface{}), 0))
+	xml["format"] = map[string]interface{}{
+		"insertSpaces": true,
+		"tabSize":      DefaultTabSize,
+	}
+
+	return map[string]interface{}{
+		"json": map[string]interface{}{},
+		"xml":  xml,
+		"yaml": yamlConfig(map[string]interface{}{}),
+		"[yaml]": map[string]interface{}{
+			"editor.tabSize":      DefaultTabSize,
+			"editor.insertSpace":  true,
+			"editor.formatOnType": false,
+		},
+		"editor": map[string]interface{}{
+			"detectIndentation": true,
+		},
+		"files": map[string]interface{}{},
+		"rome": map[string]interface{}{
+			"unstable":              true,
+			"rename":                true,
+			"require_configuration": true,
+		},
+		"evenBetterToml": tomlConfig(tomlSchemas()),
+		"bashIde":        bashConfig(),
+		"pyright": map[string]interface{}{
+			"disableOrganizeImports": true, // ruff-lsp does that
+		},
+		"python": map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"autoImportCompletions": true,
+				"logLevel":              "Trace",
+				"typeCheckingMode":      "strict",
+			},
+		},
+		// The settings of ruff-lsp, sent as its globalSettings
+		"ruff": map[string]interface{}{
+			"logLevel":        "debug",
+			"run":             "onType",
+			"organizeImports": true,
+			"fixAll":          true,
+			"codeAction": map[string]interface{}{
+				"fixViolation": map[string]interface{}{
+					"enable": true,
+				},
+				"disableRuleComment": map[string]interface{}{
+					"enable": true,
+				},
+			},
+		},
+	}
+}
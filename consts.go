@@ -1,14 +1,40 @@
 package main
 
+import "time"
+
+const (
+	LanguageServerFactor  = 1000000
+	PendingRequestsSize   = 5
+	AverageFileCount      = 2
+	LanguageServerCount   = 7
+	DefaultTabSize        = 2
+	ReadBufferSize        = 64 * 1024
+	MaxHeaderSize         = 4096
+	DefaultMaxMessageSize = 64 * 1024 * 1024
+	OutgoingQueueSize     = 64
+	MaxLogFileSize        = 10 * 1024 * 1024
+	LogFileCount          = 3
+	MaxWorkspaceSymbols   = 1000
+	YamlID                = 1
+	JSONID                = 2
+	XMLID                 = 3
+	RUFFID                = 4
+	ROMEID                = 5
+	TOMLID                = 6
+	BASHID                = 7
+)
+
+// JSON-RPC and LSP error codes
 const (
-	LanguageServerFactor = 1000000
-	PendingRequestsSize  = 5
-	AverageFileCount     = 2
-	LanguageServerCount  = 3
-	DefaultTabSize       = 2
-	YamlID               = 1
-	JSONID               = 2
-	XMLID                = 3
-	RUFFID               = 4
-	ROMEID               = 5
+	ParseError           = -32700
+	InvalidRequest       = -32600
+	MethodNotFound       = -32601
+	InvalidParams        = -32602
+	InternalError        = -32603
+	ServerNotInitialized = -32002
+	RequestFailed        = -32803
 )
+
+const Version = "0.1"
+
+const InitializeTimeout = 30 * time.Second
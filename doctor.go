@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type BackendReport struct {
+	ID          string `json:"id"`
+	Command     string `json:"command"`
+	Executable  string `json:"executable,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+	Found       bool   `json:"found"`
+	Version     string `json:"version,omitempty"`
+	Initialized bool   `json:"initialized"`
+	// Time the initialize handshake took, in milliseconds
+	InitializeTime int64  `json:"initializeTime,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+type SchemaReport struct {
+	URI       string `json:"uri"`
+	Available bool   `json:"available"`
+	// The local copy of a remote schema
+	Path string `json:"path,omitempty"`
+	// Set for remote schemas without a local copy unless --network is given
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Local copies of remote schemas, relative to $XDG_DATA_DIRS.
+var localSchemas = map[string]string{
+	GSchemaDTD: "glib-2.0/schemas/gschema.dtd",
+}
+
+type DoctorReport struct {
+	Backends []BackendReport `json:"backends"`
+	Schemas  []SchemaReport  `json:"schemas"`
+}
+
+// RunDoctor checks every backend NewServer would start and every schema the
+// configurations refer to. Remote schemas are only requested if network is set.
+func RunDoctor(options Options, timeout time.Duration, network bool) DoctorReport {
+	report := DoctorReport{
+		Backends: make([]BackendReport, len(defaultBackends)),
+		Schemas:  make([]SchemaReport, 0, len(referencedSchemas())),
+	}
+
+	var wg sync.WaitGroup
+
+	for i, backend := range defaultBackends {
+		wg.Add(1)
+
+		go func(i int, backend backendSpec) {
+			defer wg.Done()
+
+			report.Backends[i] = checkBackend(backend, options, timeout)
+		}(i, backend)
+	}
+
+	for _, schema := range referencedSchemas() {
+		report.Schemas = append(report.Schemas, SchemaReport{URI: schema})
+	}
+
+	for i := range report.Schemas {
+		wg.Add(1)
+
+		go func(schema *SchemaReport) {
+			defer wg.Done()
+
+			*schema = checkSchema(schema.URI, timeout, network)
+		}(&report.Schemas[i])
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+func checkBackend(backend backendSpec, options Options, timeout time.Duration) BackendReport {
+	report := BackendReport{ID: backend.ID, Command: backend.Command}
+
+	if options.Disabled[backend.ID] {
+		report.Disabled = true
+
+		return report
+	}
+
+	if override, ok := options.Commands[backend.ID]; ok {
+		report.Command = override
+		backend.VersionCommand = ""
+	}
+
+	factory := options.Backends
+	if factory == nil {
+		fields := strings.Fields(report.Command)
+		if len(fields) == 0 {
+			report.Error = "empty command"
+
+			return report
+		}
+
+		executable, err := exec.LookPath(fields[0])
+		if err != nil {
+			report.Error = err.Error()
+
+			return report
+		}
+
+		report.Executable = executable
+		factory = processBackend(func(string, string) {})
+	}
+
+	report.Found = true
+
+	if backend.VersionCommand != "" {
+		report.Version = commandVersion(backend.VersionCommand, timeout)
+	}
+
+	start := time.Now()
+
+	serverVersion, err := handshake(factory, backend.ID, report.Command, timeout)
+	if err != nil {
+		report.Error = err.Error()
+
+		return report
+	}
+
+	report.Initialized = true
+	report.InitializeTime = time.Since(start).Milliseconds()
+
+	if report.Version == "" {
+		report.Version = serverVersion
+	}
+
+	return report
+}
+
+func commandVersion(command string, timeout time.Duration) string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "bash", "-c", command).Output()
+	if err != nil {
+		return ""
+	}
+
+	version, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+
+	return version
+}
+
+// handshake initializes a backend and shuts it down again. It returns the
+// version from the serverInfo, if there is one. A backend failing to
+// initialize, shut down or exit is killed.
+func handshake(factory BackendFactory, id string, command string, timeout time.Duration) (string, error) {
+	rpc, err := factory(id, command)
+	if err != nil {
+		return "", err
+	}
+
+	kill := func() {
+		if process := rpc.Process(); process != nil {
+			_ = process.Kill()
+		}
+
+		rpc.Close()
+	}
+
+	pid := int32(syscall.Getpid())
+
+	data, _ := json.Marshal(makeRequest(1, "initialize", map[string]interface{}{
+		"processId":    pid,
+		"rootUri":      nil,
+		"capabilities": map[string]interface{}{},
+	}))
+	if err := rpc.SendMessage(data); err != nil {
+		kill()
+
+		return "", err
+	}
+
+	type response struct {
+		ID     interface{}     `json:"id"`
+		Method string          `json:"method"`
+		Error  *ResponseError  `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+
+	responses := make(chan response, 2)
+	exited := make(chan error, 1)
+
+	go func() {
+		for {
+			messageData, err := rpc.ReadMessage()
+			if err != nil {
+				exited <- err
+
+				return
+			}
+
+			var message response
+			if err := json.Unmarshal(messageData, &message); err != nil || message.Method != "" {
+				continue // Notifications, requests to the client and batches
+			}
+
+			if message.ID == float64(1) || message.ID == float64(2) {
+				responses <- message
+			}
+		}
+	}()
+
+	wait := func(method string) (response, error) {
+		select {
+		case message := <-responses:
+			return message, nil
+		case err := <-exited:
+			return response{}, fmt.Errorf("exited during %s: %w", method, err)
+		case <-time.After(timeout):
+			return response{}, fmt.Errorf("no response to %s within %s", method, timeout)
+		}
+	}
+
+	initialized, err := wait("initialize")
+	if err != nil {
+		kill()
+
+		return "", err
+	}
+
+	if initialized.Error != nil {
+		kill()
+
+		return "", fmt.Errorf("initialize failed: %w", initialized.Error)
+	}
+
+	var initializeResult struct {
+		ServerInfo struct {
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	_ = json.Unmarshal(initialized.Result, &initializeResult)
+
+	// A backend that doesn't shut down and exit in time is killed
+	data, _ = json.Marshal(makeRequest(2, "shutdown", nil))
+	if err := rpc.SendMessage(data); err == nil {
+		if _, err := wait("shutdown"); err == nil {
+			data, _ = json.Marshal(makeNotification("exit", nil))
+			_ = rpc.SendMessage(data)
+
+			select {
+			case <-exited:
+				rpc.Close()
+
+				return initializeResult.ServerInfo.Version, nil
+			case <-time.After(timeout):
+			}
+		}
+	}
+
+	kill()
+
+	return initializeResult.ServerInfo.Version, nil
+}
+
+// checkSchema checks that a schema is available locally. Remote schemas
+// without a local copy are requested if network is set, and skipped otherwise.
+func checkSchema(schema string, timeout time.Duration, network bool) SchemaReport {
+	report := SchemaReport{URI: schema}
+
+	parsed, err := url.Parse(schema)
+	if err != nil {
+		report.Error = err.Error()
+
+		return report
+	}
+
+	switch parsed.Scheme {
+	case "", "file":
+		if _, err := os.Stat(parsed.Path); err != nil {
+			report.Error = err.Error()
+		} else {
+			report.Available = true
+			report.Path = parsed.Path
+		}
+
+		return report
+	case "http", "https":
+	default:
+		report.Error = fmt.Sprintf("unsupported scheme %s", parsed.Scheme)
+
+		return report
+	}
+
+	if path := localSchema(schema); path != "" {
+		report.Available = true
+		report.Path = path
+
+		return report
+	}
+
+	if !network {
+		report.Skipped = true
+
+		return report
+	}
+
+	if err := probeSchema(schema, timeout); err != nil {
+		report.Error = err.Error()
+	} else {
+		report.Available = true
+	}
+
+	return report
+}
+
+// localSchema returns the path of a local copy of a remote schema, if there is
+// one.
+func localSchema(schema string) string {
+	relative, ok := localSchemas[schema]
+	if !ok {
+		return ""
+	}
+
+	directories := os.Getenv("XDG_DATA_DIRS")
+	if directories == "" {
+		directories = "/usr/local/share:/usr/share"
+	}
+
+	for _, directory := range filepath.SplitList(directories) {
+		path := filepath.Join(directory, relative)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// probeSchema checks that a remote schema can be downloaded.
+func probeSchema(schema string, timeout time.Duration) error {
+	client := http.Client{Timeout: timeout}
+
+	response, err := client.Head(schema)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusMethodNotAllowed {
+		response, err = client.Get(schema)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s", response.Status)
+	}
+
+	return nil
+}
+
+func (r DoctorReport) OK() bool {
+	for _, backend := range r.Backends {
+		if !backend.Initialized && !backend.Disabled {
+			return false
+		}
+	}
+
+	for _, schema := range r.Schemas {
+		if !schema.Available && !schema.Skipped {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r DoctorReport) WriteText(w io.Writer) {
+	status := func(ok bool) string {
+		if ok {
+			return "ok"
+		}
+
+		return "FAILED"
+	}
+
+	fmt.Fprintln(w, "Backends:")
+
+	for _, backend := range r.Backends {
+		if backend.Disabled {
+			fmt.Fprintf(w, "  %-5s %-7s %s\n", backend.ID, "skipped", "disabled")
+
+			continue
+		}
+
+		fmt.Fprintf(w, "  %-5s %-7s %s\n", backend.ID, status(backend.Initialized), backend.Command)
+
+		if backend.Executable != "" {
+			fmt.Fprintf(w, "        executable: %s\n", backend.Executable)
+		}
+
+		if backend.Version != "" {
+			fmt.Fprintf(w, "        version:    %s\n", backend.Version)
+		}
+
+		if backend.Initialized {
+			fmt.Fprintf(w, "        initialize: %d ms\n", backend.InitializeTime)
+		}
+
+		if backend.Error != "" {
+			fmt.Fprintf(w, "        error:      %s\n", backend.Error)
+		}
+	}
+
+	fmt.Fprintln(w, "Schemas:")
+
+	for _, schema := range r.Schemas {
+		if schema.Skipped {
+			fmt.Fprintf(w, "  %-7s %s\n", "skipped", schema.URI)
+
+			continue
+		}
+
+		fmt.Fprintf(w, "  %-7s %s\n", status(schema.Available), schema.URI)
+
+		if schema.Path != "" && schema.Path != strings.TrimPrefix(schema.URI, "file://") {
+			fmt.Fprintf(w, "          local copy: %s\n", schema.Path)
+		}
+
+		if schema.Error != "" {
+			fmt.Fprintf(w, "          error: %s\n", schema.Error)
+		}
+	}
+}
+
+func (r DoctorReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDoctorPerformsHandshake(t *testing.T) {
+	backend := NewFakeBackend(t, map[string]interface{}{})
+	options := Options{
+		Backends: func(id string, _ string) (*JSONRPC, error) {
+			if id != "json" {
+				return nil, errors.New("not installed")
+			}
+
+			return backend.connect(), nil
+		},
+	}
+
+	start := time.Now()
+
+	report := checkBackend(defaultBackends[0], options, testTimeout)
+	if !report.Initialized || report.Error != "" {
+		t.Fatalf("expected a successful handshake, got %+v", report)
+	}
+
+	// The fake exits as soon as it is told to
+	if elapsed := time.Since(start); elapsed >= testTimeout {
+		t.Errorf("expected the handshake to end with the exit of the backend, took %s", elapsed)
+	}
+
+	if exits := filterMethod(backend.WaitFor("exit"), "exit"); len(exits) != 1 {
+		t.Errorf("expected one exit, got %v", exits)
+	}
+
+	report = checkBackend(defaultBackends[1], options, testTimeout)
+	if report.Initialized || report.Error != "not installed" {
+		t.Errorf("expected a failed handshake, got %+v", report)
+	}
+}
+
+func TestDoctorTimesOut(t *testing.T) {
+	backend := NewFakeBackend(t, map[string]interface{}{})
+	backend.Initialize = make(chan struct{})
+	defer close(backend.Initialize)
+
+	options := Options{
+		Backends: func(string, string) (*JSONRPC, error) {
+			return backend.connect(), nil
+		},
+	}
+
+	if report := checkBackend(defaultBackends[0], options, 0); report.Initialized {
+		t.Errorf("expected a timeout, got %+v", report)
+	}
+}
+
+func TestDoctorKillsUnresponsiveBackends(t *testing.T) {
+	// cat answers nothing, it sends initialize back as a request
+	rpc, report := checkProcessBackend(t, "cat")
+	if report.Initialized {
+		t.Fatalf("expected a timeout, got %+v", report)
+	}
+
+	waitForKill(t, rpc)
+}
+
+func TestDoctorKillsBackendsThatDontExit(t *testing.T) {
+	// Answers initialize and shutdown, then ignores exit
+	rpc, report := checkProcessBackend(t, `for id in 1 2; do printf 'Content-Length: 36\r\n\r\n{"jsonrpc":"2.0","id":%d,"result":{}}' $id; done; sleep 60`)
+	if !report.Initialized || report.Error != "" {
+		t.Fatalf("expected a successful handshake, got %+v", report)
+	}
+
+	waitForKill(t, rpc)
+}
+
+// checkProcessBackend checks json started as a process running command.
+func checkProcessBackend(t *testing.T, command string) (*JSONRPC, BackendReport) {
+	t.Helper()
+
+	var rpc *JSONRPC
+
+	factory := processBackend(func(string, string) {})
+	options := Options{
+		Commands: map[string]string{"json": command},
+		Backends: func(id string, command string) (*JSONRPC, error) {
+			var err error
+			rpc, err = factory(id, command)
+
+			return rpc, err
+		},
+	}
+
+	report := checkBackend(defaultBackends[0], options, 100*time.Millisecond)
+	if rpc == nil {
+		t.Fatalf("the backend was not started: %+v", report)
+	}
+
+	return rpc, report
+}
+
+func waitForKill(t *testing.T, rpc *JSONRPC) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for syscall.Kill(rpc.Process().PID(), 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the backend is still running")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchemasAreCheckedLocally(t *testing.T) {
+	directory := t.TempDir()
+	schema := filepath.Join(directory, "schema.json")
+
+	if err := os.WriteFile(schema, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(directory, "glib-2.0", "schemas"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(directory, localSchemas[GSchemaDTD]), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("XDG_DATA_DIRS", directory)
+
+	if report := checkSchema("file://"+schema, 0, false); !report.Available {
+		t.Errorf("expected the schema to be available, got %+v", report)
+	}
+
+	if report := checkSchema("file://"+schema+".missing", 0, false); report.Available || report.Error == "" {
+		t.Errorf("expected a missing schema, got %+v", report)
+	}
+
+	if report := checkSchema(GSchemaDTD, 0, false); !report.Available || report.Path != filepath.Join(directory, localSchemas[GSchemaDTD]) {
+		t.Errorf("expected the local copy, got %+v", report)
+	}
+
+	// Never requested without --network
+	if report := checkSchema(FlatpakManifestSchema, 0, false); !report.Skipped || report.Available {
+		t.Errorf("expected the remote schema to be skipped, got %+v", report)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+type Document struct {
+	URI        string
+	LanguageID string
+	Version    int32
+	Text       string
+}
+
+type DocumentStore struct {
+	mu        sync.RWMutex
+	documents map[string]*Document
+}
+
+func NewDocumentStore() *DocumentStore {
+	return &DocumentStore{
+		documents: make(map[string]*Document, AverageFileCount),
+	}
+}
+
+func (d *DocumentStore) Open(uri string, languageID string, version int32, text string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.documents[uri] = &Document{
+		URI:        uri,
+		LanguageID: languageID,
+		Version:    version,
+		Text:       text,
+	}
+}
+
+// All returns copies of the open documents, sorted by URI.
+func (d *DocumentStore) All() []Document {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	documents := make([]Document, 0, len(d.documents))
+	for _, document := range d.documents {
+		documents = append(documents, *document)
+	}
+
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].URI < documents[j].URI
+	})
+
+	return documents
+}
+
+// Change applies changes with ranges in the from encoding. It returns the new text
+// and the changes with their ranges converted into the to encoding.
+func (d *DocumentStore) Change(uri string, version int32, changes []any, from string, to string) (string, []any, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	document, ok := d.documents[uri]
+	if !ok {
+		return "", changes, false
+	}
+
+	converted := make([]any, 0, len(changes))
+
+	for _, change := range changes {
+		switch change := change.(type) {
+		case protocol.TextDocumentContentChangeEventWhole:
+			document.Text = change.Text
+			converted = append(converted, change)
+		case protocol.TextDocumentContentChangeEvent:
+			start := offsetAt(document.Text, change.Range.Start, from)
+			end := offsetAt(document.Text, change.Range.End, from)
+
+			if end < start {
+				start, end = end, start
+			}
+
+			convertedRange := protocol.Range{
+				Start: convertPositionIn(document.Text, change.Range.Start, from, to),
+				End:   convertPositionIn(document.Text, change.Range.End, from, to),
+			}
+			converted = append(converted, protocol.TextDocumentContentChangeEvent{
+				Range: &convertedRange,
+				Text:  change.Text,
+			})
+			document.Text = document.Text[:start] + change.Text + document.Text[end:]
+		}
+	}
+
+	document.Version = version
+
+	return document.Text, converted, true
+}
+
+func (d *DocumentStore) Close(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.documents, uri)
+}
+
+func (d *DocumentStore) Get(uri string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	document, ok := d.documents[uri]
+	if !ok {
+		return "", false
+	}
+
+	return document.Text, true
+}
+
+// offsetAt converts a position into a byte offset, clamping positions past the
+// end of a line or the document.
+func offsetAt(text string, pos protocol.Position, encoding string) int {
+	offset := 0
+
+	for line := protocol.UInteger(0); line < pos.Line; line++ {
+		next := strings.IndexByte(text[offset:], '\n')
+		if next == -1 {
+			return len(text)
+		}
+
+		offset += next + 1
+	}
+
+	return offset + byteOffset(lineAt(text[offset:], 0), int(pos.Character), encoding)
+}
+
+func convertPositionIn(text string, pos protocol.Position, from string, to string) protocol.Position {
+	line := lineAt(text, int(pos.Line))
+	pos.Character = protocol.UInteger(convertCharacter(line, int(pos.Character), from, to))
+
+	return pos
+}
+
+// parseSyncKind reads the textDocumentSync server capability, which is either
+// a TextDocumentSyncKind or TextDocumentSyncOptions.
+func parseSyncKind(raw json.RawMessage) protocol.TextDocumentSyncKind {
+	var kind protocol.TextDocumentSyncKind
+	if err := json.Unmarshal(raw, &kind); err == nil {
+		return kind
+	}
+
+	var options protocol.TextDocumentSyncOptions
+	if err := json.Unmarshal(raw, &options); err == nil && options.Change != nil {
+		return *options.Change
+	}
+
+	return protocol.TextDocumentSyncKindNone
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+func changeRange(startLine, startCharacter, endLine, endCharacter int) *protocol.Range {
+	return &protocol.Range{
+		Start: protocol.Position{Line: protocol.UInteger(startLine), Character: protocol.UInteger(startCharacter)},
+		End:   protocol.Position{Line: protocol.UInteger(endLine), Character: protocol.UInteger(endCharacter)},
+	}
+}
+
+func TestIncrementalChangesAreApplied(t *testing.T) {
+	// Where 漢 in mixedLine starts and ends, and where the line ends, per encoding
+	characters := map[string][3]int{
+		PositionEncodingUTF8:  {5, 8, 9},
+		PositionEncodingUTF16: {3, 4, 5},
+		PositionEncodingUTF32: {2, 3, 4},
+	}
+
+	for _, from := range supportedPositionEncodings {
+		for _, to := range supportedPositionEncodings {
+			documents := NewDocumentStore()
+			documents.Open("file:///a.json", "json", 1, mixedLine+"\nx\n")
+
+			start, end := characters[from][0], characters[from][1]
+			text, changes, ok := documents.Change("file:///a.json", 2, []any{
+				protocol.TextDocumentContentChangeEvent{Range: changeRange(0, start, 0, end), Text: "中"},
+				protocol.TextDocumentContentChangeEvent{Range: changeRange(1, 0, 1, 1), Text: "😀"},
+				protocol.TextDocumentContentChangeEvent{Range: changeRange(0, characters[from][2], 0, characters[from][2]), Text: "!"},
+			}, from, to)
+
+			if !ok || text != "a😀中b!\n😀\n" {
+				t.Errorf("%s to %s: unexpected text %q (%v)", from, to, text, ok)
+
+				continue
+			}
+
+			converted := characters[to]
+			expected, _ := json.Marshal([]any{
+				protocol.TextDocumentContentChangeEvent{Range: changeRange(0, converted[0], 0, converted[1]), Text: "中"},
+				protocol.TextDocumentContentChangeEvent{Range: changeRange(1, 0, 1, 1), Text: "😀"},
+				protocol.TextDocumentContentChangeEvent{Range: changeRange(0, converted[2], 0, converted[2]), Text: "!"},
+			})
+			if result, _ := json.Marshal(changes); string(result) != string(expected) {
+				t.Errorf("%s to %s: expected %s, got %s", from, to, expected, result)
+			}
+		}
+	}
+}
+
+func TestWholeChangesReplaceTheText(t *testing.T) {
+	documents := NewDocumentStore()
+	documents.Open("file:///a.json", "json", 1, "{}")
+
+	text, _, ok := documents.Change("file:///a.json", 2, []any{
+		protocol.TextDocumentContentChangeEventWhole{Text: "[]"},
+		protocol.TextDocumentContentChangeEvent{Range: changeRange(0, 1, 0, 1), Text: "1"},
+		// Past the end of the document is clamped
+		protocol.TextDocumentContentChangeEvent{Range: changeRange(3, 0, 3, 0), Text: "\n"},
+	}, PositionEncodingUTF16, PositionEncodingUTF8)
+	if !ok || text != "[1]\n" {
+		t.Errorf("expected [1] and a newline, got %q (%v)", text, ok)
+	}
+
+	if _, _, ok := documents.Change("file:///b.json", 1, nil, PositionEncodingUTF16, PositionEncodingUTF8); ok {
+		t.Errorf("expected a document that isn't open not to be changed")
+	}
+}
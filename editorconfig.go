@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const EditorConfigName = ".editorconfig"
+
+type editorConfigSection struct {
+	pattern    *regexp.Regexp
+	properties map[string]string
+}
+
+type editorConfig struct {
+	root     bool
+	sections []editorConfigSection
+}
+
+// editorConfigPattern converts a section name of an .editorconfig into a
+// regular expression matching paths relative to its directory.
+func editorConfigPattern(glob string) (*regexp.Regexp, error) {
+	if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+
+	return compileGlob(strings.TrimPrefix(glob, "/"))
+}
+
+// compileGlob converts a glob into a regular expression matching whole paths.
+// * and ? don't match /, ** matches any number of directories, {a,b} matches
+// a or b and [!a] any character but a.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+
+	pattern.WriteString("^")
+
+	braces := 0
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			pattern.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			pattern.WriteString(".*")
+			i++
+		case c == '*':
+			pattern.WriteString("[^/]*")
+		case c == '?':
+			pattern.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end == -1 {
+				pattern.WriteString(`\[`)
+
+				continue
+			}
+
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			pattern.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end
+		case c == '{':
+			end := strings.IndexByte(glob[i:], '}')
+			if end != -1 && regexp.MustCompile(`^-?\d+\.\.-?\d+$`).MatchString(glob[i+1:i+end]) {
+				// Numeric ranges match any number
+				pattern.WriteString(`-?\d+`)
+				i += end
+
+				continue
+			}
+
+			braces++
+			pattern.WriteString("(?:")
+		case c == '}' && braces > 0:
+			braces--
+			pattern.WriteString(")")
+		case c == ',' && braces > 0:
+			pattern.WriteString("|")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	pattern.WriteString("$")
+
+	return regexp.Compile(pattern.String())
+}
+
+func parseEditorConfig(path string) (editorConfig, error) {
+	var config editorConfig
+
+	file, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer file.Close()
+
+	var section *editorConfigSection
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = nil
+
+			pattern, err := editorConfigPattern(line[1 : len(line)-1])
+			if err != nil {
+				continue // Properties of invalid sections are ignored
+			}
+
+			config.sections = append(config.sections, editorConfigSection{
+				pattern:    pattern,
+				properties: make(map[string]string),
+			})
+			section = &config.sections[len(config.sections)-1]
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = strings.ToLower(strings.TrimSpace(value))
+
+			if section != nil {
+				section.properties[key] = value
+			} else if key == "root" {
+				config.root = value == "true"
+			}
+		}
+	}
+
+	return config, scanner.Err()
+}
+
+// editorConfigProperties returns the .editorconfig properties of a file. The
+// .editorconfig files closest to the file take precedence, the search stops at
+// one marked as root.
+func editorConfigProperties(path string) map[string]string {
+	properties := make(map[string]string)
+
+	for directory := filepath.Dir(path); ; directory = filepath.Dir(directory) {
+		config, err := parseEditorConfig(filepath.Join(directory, EditorConfigName))
+		if err == nil {
+			relative, _ := filepath.Rel(directory, path)
+			found := make(map[string]string)
+
+			// Later sections take precedence
+			for _, section := range config.sections {
+				if section.pattern.MatchString(filepath.ToSlash(relative)) {
+					for key, value := range section.properties {
+						found[key] = value
+					}
+				}
+			}
+
+			for key, value := range found {
+				if _, ok := properties[key]; !ok {
+					properties[key] = value
+				}
+			}
+
+			if config.root {
+				break
+			}
+		}
+
+		if parent := filepath.Dir(directory); parent == directory {
+			break
+		}
+	}
+
+	return properties
+}
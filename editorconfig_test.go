@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEditorConfigPattern(t *testing.T) {
+	for glob, paths := range map[string]map[string]bool{
+		"*":                 {"a.xml": true, "sub/a.xml": true},
+		"*.{yaml,yml}":      {"a.yaml": true, "sub/a.yml": true, "a.json": false},
+		"/sub/*.xml":        {"sub/a.xml": true, "other/sub/a.xml": false, "sub/deep/a.xml": false},
+		"sub/**.xml":        {"sub/a.xml": true, "sub/deep/a.xml": true},
+		"[!a]?.json":        {"bc.json": true, "ac.json": false},
+		"file{1..3}.toml":   {"file2.toml": true, "file.toml": false},
+		"Makefile":          {"Makefile": true, "sub/Makefile": true, "Makefile.am": false},
+		"docs/**/index.xml": {"docs/index.xml": true, "docs/a/b/index.xml": true},
+	} {
+		pattern, err := editorConfigPattern(glob)
+		if err != nil {
+			t.Fatalf("unable to convert %s: %s", glob, err)
+		}
+
+		for path, matches := range paths {
+			if pattern.MatchString(path) != matches {
+				t.Errorf("expected %s matching %s to be %v", glob, path, matches)
+			}
+		}
+	}
+}
+
+func TestEditorConfigOptions(t *testing.T) {
+	directory := t.TempDir()
+	root := `root = true
+
+[*]
+indent_style = space
+indent_size = 4
+insert_final_newline = true
+
+[*.xml]
+indent_size = 3
+`
+	nested := `[*.xml]
+indent_style = tab
+tab_width = 8
+`
+
+	if err := os.MkdirAll(filepath.Join(directory, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(directory, ".editorconfig"), []byte(root), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(directory, "sub", ".editorconfig"), []byte(nested), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]map[string]interface{}{
+		"a.json":     {"insertSpaces": true, "tabSize": 4, "insertFinalNewline": true},
+		"a.xml":      {"insertSpaces": true, "tabSize": 3, "insertFinalNewline": true},
+		"sub/a.xml":  {"insertSpaces": false, "tabSize": 8, "insertFinalNewline": true},
+		"sub/a.yaml": {"insertSpaces": true, "tabSize": 4, "insertFinalNewline": true},
+	} {
+		if options := editorConfigOptions(filepath.Join(directory, path)); !reflect.DeepEqual(options, expected) {
+			t.Errorf("expected %v for %s, got %v", expected, path, options)
+		}
+	}
+}
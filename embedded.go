@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"gopkg.in/yaml.v3"
+)
+
+// A shell snippet extracted from a YAML scalar. starts[i] is the position in the
+// YAML document where line i of the snippet begins, counted in encoding.
+type shellSnippet struct {
+	text     string
+	starts   []protocol.Position
+	encoding string
+}
+
+type EmbeddedDocument struct {
+	URI         string
+	Parent      string
+	Version     int32
+	Snippet     shellSnippet
+	Diagnostics []protocol.Diagnostic
+}
+
+func shellKeysForFile(uri string, isFlatpak bool) map[string]bool {
+	name := path.Base(uri)
+
+	switch {
+	case strings.Contains(uri, "/.github/workflows/") || strings.Contains(uri, "/.github/actions/"):
+		return map[string]bool{"run": true}
+	case strings.HasSuffix(name, ".gitlab-ci.yml") || strings.Contains(uri, "/.gitlab/ci/"):
+		return map[string]bool{"script": true, "before_script": true, "after_script": true}
+	case isFlatpak:
+		return map[string]bool{"build-commands": true, "post-install": true}
+	}
+
+	return nil
+}
+
+func extractShellSnippets(text string, keys map[string]bool, encoding string) ([]shellSnippet, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &root); err != nil {
+		return nil, fmt.Errorf("extractShellSnippets(): %w", err)
+	}
+
+	lines := strings.Split(text, "\n")
+	snippets := make([]shellSnippet, 0)
+
+	var walk func(node *yaml.Node, collect bool)
+	walk = func(node *yaml.Node, collect bool) {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			if !collect {
+				return
+			}
+
+			if snippet, ok := snippetFromScalar(node, lines, encoding); ok {
+				snippets = append(snippets, snippet)
+			}
+		case yaml.SequenceNode:
+			for _, child := range node.Content {
+				walk(child, collect)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(node.Content[i+1], keys[node.Content[i].Value])
+			}
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, false)
+			}
+		case yaml.AliasNode:
+		}
+	}
+	walk(&root, false)
+
+	return snippets, nil
+}
+
+func snippetFromScalar(node *yaml.Node, lines []string, encoding string) (shellSnippet, bool) {
+	switch node.Style {
+	case yaml.LiteralStyle:
+		// The content starts on the line after the indicator, indented by the
+		// indentation of its first non-empty line.
+		contentLines := strings.Split(strings.TrimRight(node.Value, "\n"), "\n")
+		indent := -1
+
+		for i := node.Line; i < len(lines) && i < node.Line+len(contentLines); i++ {
+			if strings.TrimSpace(lines[i]) != "" {
+				indent = len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+
+				break
+			}
+		}
+
+		if indent == -1 {
+			return shellSnippet{}, false
+		}
+
+		starts := make([]protocol.Position, len(contentLines))
+		for i := range contentLines {
+			starts[i] = protocol.Position{
+				Line:      protocol.UInteger(node.Line + i),
+				Character: protocol.UInteger(indent),
+			}
+		}
+
+		return shellSnippet{text: node.Value, starts: starts, encoding: encoding}, true
+	case yaml.FoldedStyle:
+		// Folding joins lines, so there is no line by line mapping back.
+		return shellSnippet{}, false
+	case 0, yaml.DoubleQuotedStyle, yaml.SingleQuotedStyle:
+		if node.Line < 1 || node.Line > len(lines) {
+			return shellSnippet{}, false
+		}
+
+		// Like folding, continuation lines and escapes make the value differ
+		// from the source, so only scalars written as they are can be mapped.
+		prefix := firstRunes(lines[node.Line-1], node.Column-1)
+		if !strings.HasPrefix(lines[node.Line-1][len(prefix):], scalarSource(node)) {
+			return shellSnippet{}, false
+		}
+
+		character := encodedLength(prefix, encoding)
+		if node.Style != 0 {
+			character++
+		}
+
+		return shellSnippet{
+			text: node.Value,
+			starts: []protocol.Position{{
+				Line:      protocol.UInteger(node.Line - 1),
+				Character: protocol.UInteger(character),
+			}},
+			encoding: encoding,
+		}, true
+	case yaml.TaggedStyle, yaml.FlowStyle:
+	}
+
+	return shellSnippet{}, false
+}
+
+// scalarSource returns how a flow scalar is written if it has no escapes and
+// fits on one line.
+func scalarSource(node *yaml.Node) string {
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		return `"` + node.Value + `"`
+	case yaml.SingleQuotedStyle:
+		return "'" + node.Value + "'"
+	}
+
+	return node.Value
+}
+
+func firstRunes(line string, count int) string {
+	for i := range line {
+		if count == 0 {
+			return line[:i]
+		}
+
+		count--
+	}
+
+	return line
+}
+
+func (snippet *shellSnippet) toParent(pos protocol.Position) protocol.Position {
+	if len(snippet.starts) == 0 {
+		return pos
+	}
+
+	line := int(pos.Line)
+	if line >= len(snippet.starts) {
+		line = len(snippet.starts) - 1
+	}
+
+	return protocol.Position{
+		Line:      snippet.starts[line].Line,
+		Character: snippet.starts[line].Character + pos.Character,
+	}
+}
+
+func (snippet *shellSnippet) fromParent(pos protocol.Position) (protocol.Position, bool) {
+	if len(snippet.starts) == 0 || pos.Line < snippet.starts[0].Line {
+		return pos, false
+	}
+
+	line := int(pos.Line - snippet.starts[0].Line)
+	if line >= len(snippet.starts) || pos.Character < snippet.starts[line].Character {
+		return pos, false
+	}
+
+	contentLines := strings.Split(snippet.text, "\n")
+	character := pos.Character - snippet.starts[line].Character
+
+	if line < len(contentLines) && int(character) > encodedLength(contentLines[line], snippet.encoding) {
+		return pos, false
+	}
+
+	return protocol.Position{Line: protocol.UInteger(line), Character: character}, true
+}
+
+func (snippet *shellSnippet) rangeToParent(r protocol.Range) protocol.Range {
+	return protocol.Range{
+		Start: snippet.toParent(r.Start),
+		End:   snippet.toParent(r.End),
+	}
+}
+
+func (s *Server) updateEmbeddedDocuments(uri string, text string) {
+	s.mu.RLock()
+	isFlatpak := s.yamlFlatpakManifests.Contains(path.Base(uri))
+	s.mu.RUnlock()
+
+	keys := shellKeysForFile(uri, isFlatpak)
+	if keys == nil {
+		return
+	}
+
+	snippets, err := extractShellSnippets(text, keys, s.editorEncoding())
+	if err != nil {
+		// Keep the old snippets while the user is typing invalid YAML
+		s.logger.Infof("Unable to extract shell snippets from %s: %s", uri, err)
+
+		return
+	}
+
+	s.mu.Lock()
+	old := s.embedded[uri]
+	documents := make([]*EmbeddedDocument, 0, len(snippets))
+
+	var messages []map[string]interface{}
+
+	for i, snippet := range snippets {
+		if i < len(old) {
+			document := old[i]
+			if document.Snippet.text != snippet.text {
+				document.Version++
+				messages = append(messages, makeNotification("textDocument/didChange", map[string]interface{}{
+					"textDocument": map[string]interface{}{
+						"uri":     document.URI,
+						"version": document.Version,
+					},
+					"contentChanges": []interface{}{
+						map[string]interface{}{"text": snippet.text},
+					},
+				}))
+			}
+
+			document.Snippet = snippet
+			documents = append(documents, document)
+
+			continue
+		}
+
+		document := &EmbeddedDocument{
+			URI:     fmt.Sprintf("%s.proxy-ls-%d.sh", uri, i),
+			Parent:  uri,
+			Version: 1,
+			Snippet: snippet,
+		}
+		documents = append(documents, document)
+		s.virtualDocuments[document.URI] = document
+		messages = append(messages, makeNotification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"uri":        document.URI,
+				"languageId": "shellscript",
+				"version":    document.Version,
+				"text":       snippet.text,
+			},
+		}))
+	}
+
+	for i := len(snippets); i < len(old); i++ {
+		delete(s.virtualDocuments, old[i].URI)
+		messages = append(messages, makeNotification("textDocument/didClose", map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"uri": old[i].URI,
+			},
+		}))
+	}
+
+	s.embedded[uri] = documents
+	s.mu.Unlock()
+
+	for _, message := range messages {
+		if err := s.redirectNotification("bash", message); err != nil {
+			s.logger.Warnf("Unable to update embedded shell scripts of %s: %s", uri, err)
+
+			break
+		}
+	}
+
+	if err := s.publishDiagnostics(); err != nil {
+		s.logger.Warnf("Unable to publish diagnostics: %s", err)
+	}
+}
+
+func (s *Server) closeEmbeddedDocuments(uri string) {
+	s.mu.Lock()
+	old := s.embedded[uri]
+	delete(s.embedded, uri)
+
+	for _, document := range old {
+		delete(s.virtualDocuments, document.URI)
+	}
+	s.mu.Unlock()
+
+	for _, document := range old {
+		err := s.redirectNotification("bash", makeNotification("textDocument/didClose", map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"uri": document.URI,
+			},
+		}))
+		if err != nil {
+			s.logger.Warnf("Unable to close embedded shell scripts of %s: %s", uri, err)
+
+			break
+		}
+	}
+}
+
+// embeddedDocumentAt returns the virtual document containing pos in the YAML
+// document uri together with the translated position.
+func (s *Server) embeddedDocumentAt(uri string, pos protocol.Position) (*EmbeddedDocument, protocol.Position, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, document := range s.embedded[uri] {
+		if translated, ok := document.Snippet.fromParent(pos); ok {
+			return document, translated, true
+		}
+	}
+
+	return nil, pos, false
+}
+
+func (s *Server) redirectEmbeddedHover(request map[string]interface{}, params protocol.HoverParams) (bool, error) {
+	document, pos, ok := s.embeddedDocumentAt(params.TextDocument.URI, params.Position)
+	if !ok {
+		return false, nil
+	}
+
+	params.TextDocument.URI = document.URI
+	params.Position = pos
+	request["params"] = params
+
+	err := s.redirectRequest("bash", request, func(result interface{}) interface{} {
+		var hover protocol.Hover
+
+		data, _ := json.Marshal(result)
+		if err := json.Unmarshal(data, &hover); err != nil || hover.Range == nil {
+			return result
+		}
+
+		s.mu.RLock()
+		mapped := document.Snippet.rangeToParent(*hover.Range)
+		s.mu.RUnlock()
+		hover.Range = &mapped
+
+		return hover
+	})
+
+	return true, err
+}
+
+func (s *Server) embeddedDiagnosticsLocked(uri string) []protocol.Diagnostic {
+	diagnostics := make([]protocol.Diagnostic, 0)
+
+	for _, document := range s.embedded[uri] {
+		for _, diagnostic := range document.Diagnostics {
+			diagnostic.Range = document.Snippet.rangeToParent(diagnostic.Range)
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+
+	return diagnostics
+}
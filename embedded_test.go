@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"gopkg.in/yaml.v3"
+)
+
+var runKeys = map[string]bool{"run": true}
+
+func TestExtractShellSnippets(t *testing.T) {
+	for _, test := range []struct {
+		yaml     string
+		expected []string
+	}{
+		{"run: echo a\n", []string{"echo a"}},
+		{"steps:\n  - run: |\n      echo a\n      echo b\n  - name: x\n", []string{"echo a\necho b\n"}},
+		{"run: 'echo a'\nname: echo b\n", []string{"echo a"}},
+		{"jobs:\n  a:\n    run: \"echo a\"\n  b:\n    run: [echo b]\n", []string{"echo a", "echo b"}},
+		// Folded scalars have no line by line mapping
+		{"run: >\n  echo a\n  echo b\n", []string{}},
+		{"run: echo a\n  echo b\n", []string{}},
+		{"run: \"echo \\\"a\\\"\"\n", []string{}},
+		{"run: \"echo\\ta\"\n", []string{}},
+		{"run: 'it''s'\n", []string{}},
+		{"run: \"echo a\n  echo b\"\n", []string{}},
+	} {
+		snippets, err := extractShellSnippets(test.yaml, runKeys, PositionEncodingUTF16)
+		if err != nil {
+			t.Errorf("%q: %s", test.yaml, err)
+
+			continue
+		}
+
+		texts := make([]string, 0, len(snippets))
+		for _, snippet := range snippets {
+			texts = append(texts, snippet.text)
+		}
+
+		if fmt.Sprintf("%q", texts) != fmt.Sprintf("%q", test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.yaml, test.expected, texts)
+		}
+	}
+
+	if _, err := extractShellSnippets("run: [", runKeys, PositionEncodingUTF16); err == nil {
+		t.Errorf("expected an error for invalid YAML")
+	}
+}
+
+func TestSnippetFromScalarStarts(t *testing.T) {
+	for _, test := range []struct {
+		yaml     string
+		encoding string
+		expected []protocol.Position
+	}{
+		{"run: echo a\n", PositionEncodingUTF16, []protocol.Position{{Line: 0, Character: 5}}},
+		{"run: \"echo a\"\n", PositionEncodingUTF16, []protocol.Position{{Line: 0, Character: 6}}},
+		{"- run: |\n    echo a\n    echo b\n", PositionEncodingUTF16, []protocol.Position{{Line: 1, Character: 4}, {Line: 2, Character: 4}}},
+		// The key is counted in the encoding of the editor
+		{"😀: 1\nrun: 'echo'\n", PositionEncodingUTF16, []protocol.Position{{Line: 1, Character: 6}}},
+		{"{😀: 1, run: echo}\n", PositionEncodingUTF16, []protocol.Position{{Line: 0, Character: 13}}},
+		{"{😀: 1, run: echo}\n", PositionEncodingUTF8, []protocol.Position{{Line: 0, Character: 15}}},
+		{"{😀: 1, run: echo}\n", PositionEncodingUTF32, []protocol.Position{{Line: 0, Character: 12}}},
+	} {
+		var root yaml.Node
+		if err := yaml.Unmarshal([]byte(test.yaml), &root); err != nil {
+			t.Fatal(err)
+		}
+
+		node := findValue(&root, "run")
+		if node == nil {
+			t.Fatalf("%q: no run key", test.yaml)
+		}
+
+		snippet, ok := snippetFromScalar(node, strings.Split(test.yaml, "\n"), test.encoding)
+		if !ok || fmt.Sprint(snippet.starts) != fmt.Sprint(test.expected) {
+			t.Errorf("%q in %s: expected %v, got %v (%v)", test.yaml, test.encoding, test.expected, snippet.starts, ok)
+		}
+	}
+}
+
+func findValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		if found := findValue(child, key); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func TestSnippetPositionsMapBothWays(t *testing.T) {
+	snippets, err := extractShellSnippets("run: |\n  echo a\n  echo 😀b\n", runKeys, PositionEncodingUTF16)
+	if err != nil || len(snippets) != 1 {
+		t.Fatalf("expected one snippet, got %v (%v)", snippets, err)
+	}
+
+	snippet := snippets[0]
+
+	for _, test := range []struct {
+		parent   protocol.Position
+		expected protocol.Position
+		ok       bool
+	}{
+		{protocol.Position{Line: 1, Character: 2}, protocol.Position{Line: 0, Character: 0}, true},
+		{protocol.Position{Line: 2, Character: 9}, protocol.Position{Line: 1, Character: 7}, true},
+		{protocol.Position{Line: 2, Character: 10}, protocol.Position{Line: 1, Character: 8}, true},
+		// Before the snippet, in the indentation and past the end of a line
+		{protocol.Position{Line: 0, Character: 5}, protocol.Position{}, false},
+		{protocol.Position{Line: 1, Character: 1}, protocol.Position{}, false},
+		{protocol.Position{Line: 1, Character: 9}, protocol.Position{}, false},
+		{protocol.Position{Line: 4, Character: 2}, protocol.Position{}, false},
+	} {
+		position, ok := snippet.fromParent(test.parent)
+		if ok != test.ok || (ok && position != test.expected) {
+			t.Errorf("%v: expected %v (%v), got %v (%v)", test.parent, test.expected, test.ok, position, ok)
+		}
+
+		if ok && snippet.toParent(position) != test.parent {
+			t.Errorf("%v: mapped back to %v", test.parent, snippet.toParent(position))
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+const (
+	PositionEncodingUTF8  = "utf-8"
+	PositionEncodingUTF16 = "utf-16"
+	PositionEncodingUTF32 = "utf-32"
+)
+
+var supportedPositionEncodings = []string{PositionEncodingUTF16, PositionEncodingUTF8, PositionEncodingUTF32}
+
+// negotiatePositionEncoding picks the first encoding offered by a client that
+// proxy-ls is able to convert. Without an offer, LSP mandates UTF-16.
+func negotiatePositionEncoding(offered []interface{}) string {
+	for _, encoding := range offered {
+		for _, supported := range supportedPositionEncodings {
+			if encoding == supported {
+				return supported
+			}
+		}
+	}
+
+	return PositionEncodingUTF16
+}
+
+// preferredPositionEncodings lists the supported encodings with the one of the
+// editor first, so that backends only need conversion if they can't do that.
+func preferredPositionEncodings(editorEncoding string) []string {
+	encodings := []string{editorEncoding}
+
+	for _, encoding := range supportedPositionEncodings {
+		if encoding != editorEncoding {
+			encodings = append(encodings, encoding)
+		}
+	}
+
+	return encodings
+}
+
+func runeLength(r rune, encoding string) int {
+	switch encoding {
+	case PositionEncodingUTF8:
+		return utf8.RuneLen(r)
+	case PositionEncodingUTF32:
+		return 1
+	}
+
+	if r >= 0x10000 {
+		return 2
+	}
+
+	return 1
+}
+
+func encodedLength(text string, encoding string) int {
+	length := 0
+
+	for _, r := range text {
+		length += runeLength(r, encoding)
+	}
+
+	return length
+}
+
+// byteOffset converts a character offset in the given encoding into a byte
+// offset into line. Offsets past the end of the line are clamped.
+func byteOffset(line string, character int, encoding string) int {
+	units := 0
+
+	for i, r := range line {
+		if units >= character || r == '\n' || r == '\r' {
+			return i
+		}
+
+		units += runeLength(r, encoding)
+	}
+
+	return len(line)
+}
+
+func convertCharacter(line string, character int, from string, to string) int {
+	if from == to {
+		return character
+	}
+
+	return encodedLength(line[:byteOffset(line, character, from)], to)
+}
+
+func lineAt(text string, line int) string {
+	for ; line > 0; line-- {
+		next := strings.IndexByte(text, '\n')
+		if next == -1 {
+			return ""
+		}
+
+		text = text[next+1:]
+	}
+
+	if end := strings.IndexByte(text, '\n'); end != -1 {
+		return text[:end]
+	}
+
+	return text
+}
+
+type positionConverter struct {
+	from   string
+	to     string
+	lookup func(uri string) (string, bool)
+	cache  map[string]string
+}
+
+func (s *Server) newPositionConverter(from string, to string) *positionConverter {
+	return &positionConverter{
+		from:   from,
+		to:     to,
+		lookup: s.documentText,
+		cache:  make(map[string]string, 1),
+	}
+}
+
+func (c *positionConverter) text(uri string) (string, bool) {
+	if text, ok := c.cache[uri]; ok {
+		return text, true
+	}
+
+	text, ok := c.lookup(uri)
+	if ok {
+		c.cache[uri] = text
+	}
+
+	return text, ok
+}
+
+func (c *positionConverter) convertPosition(uri string, pos protocol.Position) protocol.Position {
+	text, ok := c.text(uri)
+	if !ok {
+		return pos
+	}
+
+	return convertPositionIn(text, pos, c.from, c.to)
+}
+
+// Convert rewrites every position inside a decoded JSON value. uri is the
+// document positions refer to unless a nested object names another one.
+func (c *positionConverter) Convert(value interface{}, uri string) interface{} {
+	if c.from == c.to {
+		return value
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		line, isLine := value["line"].(float64)
+		character, isCharacter := value["character"].(float64)
+
+		if isLine && isCharacter && len(value) == 2 {
+			converted := c.convertPosition(uri, protocol.Position{
+				Line:      protocol.UInteger(line),
+				Character: protocol.UInteger(character),
+			})
+			value["character"] = converted.Character
+
+			return value
+		}
+
+		// Folding ranges give their lines and characters separately
+		if startLine, ok := value["startLine"].(float64); ok {
+			c.convertCharacterAt(value, uri, "startCharacter", startLine)
+		}
+
+		if endLine, ok := value["endLine"].(float64); ok {
+			c.convertCharacterAt(value, uri, "endCharacter", endLine)
+		}
+
+		inner := uri
+		if target, ok := value["uri"].(string); ok {
+			inner = target
+		} else if target, ok := value["targetUri"].(string); ok {
+			inner = target
+		} else if document, ok := value["textDocument"].(map[string]interface{}); ok {
+			if target, ok := document["uri"].(string); ok {
+				inner = target
+			}
+		}
+
+		for key, child := range value {
+			switch key {
+			case "originSelectionRange":
+				value[key] = c.Convert(child, uri)
+			case "changes":
+				if changes, ok := child.(map[string]interface{}); ok {
+					for target, edits := range changes {
+						changes[target] = c.Convert(edits, target)
+					}
+
+					continue
+				}
+
+				value[key] = c.Convert(child, inner)
+			default:
+				value[key] = c.Convert(child, inner)
+			}
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = c.Convert(child, uri)
+		}
+	}
+
+	return value
+}
+
+func (c *positionConverter) convertCharacterAt(value map[string]interface{}, uri string, key string, line float64) {
+	if character, ok := value[key].(float64); ok {
+		converted := c.convertPosition(uri, protocol.Position{
+			Line:      protocol.UInteger(line),
+			Character: protocol.UInteger(character),
+		})
+		value[key] = converted.Character
+	}
+}
+
+func (s *Server) documentText(uri string) (string, bool) {
+	if text, ok := s.documents.Get(uri); ok {
+		return text, true
+	}
+
+	s.mu.RLock()
+	document, ok := s.virtualDocuments[uri]
+
+	var text string
+	if ok {
+		text = document.Snippet.text
+	}
+	s.mu.RUnlock()
+
+	if ok {
+		return text, true
+	}
+
+	path := uriPath(uri)
+	if path == "" {
+		return "", false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+
+	return string(data), true
+}
+
+func (s *Server) backendEncoding(id string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if backend, ok := s.backends[id]; ok {
+		return backend.encoding
+	}
+
+	return PositionEncodingUTF16
+}
+
+func documentURI(params interface{}) string {
+	if params, ok := params.(map[string]interface{}); ok {
+		if document, ok := params["textDocument"].(map[string]interface{}); ok {
+			if uri, ok := document["uri"].(string); ok {
+				return uri
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+// An emoji outside the BMP and a CJK character: a😀漢b has 9 bytes, 5 UTF-16
+// code units and 4 code points.
+const mixedLine = "a😀漢b"
+
+func TestConvertCharacter(t *testing.T) {
+	for _, test := range []struct {
+		line      string
+		character int
+		from      string
+		to        string
+		expected  int
+	}{
+		{mixedLine, 1, PositionEncodingUTF16, PositionEncodingUTF8, 1},
+		{mixedLine, 3, PositionEncodingUTF16, PositionEncodingUTF8, 5},
+		{mixedLine, 4, PositionEncodingUTF16, PositionEncodingUTF8, 8},
+		{mixedLine, 4, PositionEncodingUTF16, PositionEncodingUTF32, 3},
+		{mixedLine, 8, PositionEncodingUTF8, PositionEncodingUTF16, 4},
+		{mixedLine, 2, PositionEncodingUTF32, PositionEncodingUTF8, 5},
+		{mixedLine, 3, PositionEncodingUTF32, PositionEncodingUTF16, 4},
+		// Inside a surrogate pair or a multi-byte sequence, the next character is meant
+		{mixedLine, 2, PositionEncodingUTF16, PositionEncodingUTF8, 5},
+		{mixedLine, 6, PositionEncodingUTF8, PositionEncodingUTF16, 4},
+		// Past the end of the line
+		{mixedLine, 100, PositionEncodingUTF16, PositionEncodingUTF8, 9},
+		{"ab\r\n", 10, PositionEncodingUTF16, PositionEncodingUTF8, 2},
+		{"", 3, PositionEncodingUTF32, PositionEncodingUTF16, 0},
+	} {
+		if converted := convertCharacter(test.line, test.character, test.from, test.to); converted != test.expected {
+			t.Errorf("%q: %d from %s to %s is %d, expected %d", test.line, test.character, test.from, test.to, converted, test.expected)
+		}
+	}
+}
+
+func TestConvertCharacterRoundTrips(t *testing.T) {
+	for _, from := range supportedPositionEncodings {
+		for _, to := range supportedPositionEncodings {
+			// Every character boundary in the from encoding
+			boundary := 0
+
+			for _, r := range mixedLine + " " {
+				converted := convertCharacter(mixedLine, boundary, from, to)
+				if back := convertCharacter(mixedLine, converted, to, from); back != boundary {
+					t.Errorf("%d from %s to %s and back is %d", boundary, from, to, back)
+				}
+
+				boundary += runeLength(r, from)
+			}
+		}
+	}
+}
+
+func TestPositionConverterConvertsEveryDocument(t *testing.T) {
+	texts := map[string]string{
+		"file:///a.json": "{}\n" + mixedLine + "\n",
+		"file:///b.json": "漢漢漢\n",
+	}
+	converter := &positionConverter{
+		from: PositionEncodingUTF16,
+		to:   PositionEncodingUTF8,
+		lookup: func(uri string) (string, bool) {
+			text, ok := texts[uri]
+
+			return text, ok
+		},
+		cache: make(map[string]string),
+	}
+
+	position := func(line int, character int) map[string]interface{} {
+		return map[string]interface{}{"line": float64(line), "character": float64(character)}
+	}
+	edit := func(line int, character int) map[string]interface{} {
+		return map[string]interface{}{
+			"range":   map[string]interface{}{"start": position(line, character), "end": position(line, character)},
+			"newText": "x",
+		}
+	}
+
+	converted := converter.Convert(map[string]interface{}{
+		"changes": map[string]interface{}{
+			"file:///a.json": []interface{}{edit(1, 4)},
+			"file:///b.json": []interface{}{edit(0, 2)},
+		},
+		"location": map[string]interface{}{
+			"uri":   "file:///b.json",
+			"range": map[string]interface{}{"start": position(0, 1), "end": position(0, 3)},
+		},
+		"position": position(1, 3),
+	}, "file:///a.json")
+
+	expected := "map[changes:map[" +
+		"file:///a.json:[map[newText:x range:map[end:map[character:8 line:1] start:map[character:8 line:1]]]] " +
+		"file:///b.json:[map[newText:x range:map[end:map[character:6 line:0] start:map[character:6 line:0]]]]] " +
+		"location:map[range:map[end:map[character:9 line:0] start:map[character:3 line:0]] uri:file:///b.json] " +
+		"position:map[character:5 line:1]]"
+	if result := fmt.Sprint(converted); result != expected {
+		t.Errorf("unexpected conversion\n%s\nexpected\n%s", result, expected)
+	}
+}
+
+func TestNegotiatePositionEncoding(t *testing.T) {
+	if encoding := negotiatePositionEncoding([]interface{}{"utf-7", PositionEncodingUTF8}); encoding != PositionEncodingUTF8 {
+		t.Errorf("expected utf-8, got %s", encoding)
+	}
+
+	if encoding := negotiatePositionEncoding(nil); encoding != PositionEncodingUTF16 {
+		t.Errorf("expected the utf-16 default, got %s", encoding)
+	}
+}
+
+func TestFoldingRangesAreConverted(t *testing.T) {
+	converter := &positionConverter{
+		from: PositionEncodingUTF8,
+		to:   PositionEncodingUTF16,
+		lookup: func(string) (string, bool) {
+			return "{\n" + mixedLine + "\n}\n", true
+		},
+		cache: make(map[string]string),
+	}
+
+	converted := converter.Convert([]interface{}{
+		map[string]interface{}{"startLine": float64(1), "startCharacter": float64(8), "endLine": float64(2), "endCharacter": float64(1)},
+		map[string]interface{}{"startLine": float64(0), "endLine": float64(1)},
+	}, "file:///a.json")
+
+	expected := "[map[endCharacter:1 endLine:2 startCharacter:4 startLine:1] map[endLine:1 startLine:0]]"
+	if result := fmt.Sprint(converted); result != expected {
+		t.Errorf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestFilesWithEscapedURIsAreRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "with space ü.json")
+	if err := os.WriteFile(path, []byte(mixedLine), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	uri := pathURI(path)
+	if !strings.Contains(uri, "%20") || uriPath(uri) != path {
+		t.Errorf("expected %s to be escaped and parsed back, got %s", path, uri)
+	}
+
+	server := &Server{documents: NewDocumentStore()}
+	if text, ok := server.documentText(uri); !ok || text != mixedLine {
+		t.Errorf("expected the text of %s, got %q (%v)", uri, text, ok)
+	}
+
+	pattern, err := compileGlob("**/with space*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := fileWatcher{pattern: pattern, kind: DefaultWatchKind}
+	if !watcher.matches(protocol.FileEvent{URI: uri, Type: protocol.FileChangeTypeChanged}) {
+		t.Errorf("expected a watcher to match %s", uri)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+// A FakeBackend is an in-process language server. It answers initialize with
+// Capabilities, requests with the canned Responses and records every message
+// it receives.
+type FakeBackend struct {
+	Capabilities map[string]interface{}
+	Responses    map[string]interface{}
+	// Released to answer initialize, by default it is answered at once
+	Initialize chan struct{}
+
+	t        *testing.T
+	failures *testFailures
+	rpc      *JSONRPC
+	mu       sync.Mutex
+	received []map[string]interface{}
+	changed  chan struct{}
+	seq      int
+	replies  map[string]chan map[string]interface{}
+}
+
+// testFailures collects the failures of goroutines serving a test, they are
+// reported once the test ends instead of from the goroutines.
+type testFailures struct {
+	mu       sync.Mutex
+	failures []string
+}
+
+func newTestFailures(t *testing.T) *testFailures {
+	t.Helper()
+
+	failures := &testFailures{}
+	t.Cleanup(func() {
+		failures.mu.Lock()
+		defer failures.mu.Unlock()
+
+		for _, failure := range failures.failures {
+			t.Error(failure)
+		}
+	})
+
+	return failures
+}
+
+func (f *testFailures) Add(format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func NewFakeBackend(t *testing.T, capabilities map[string]interface{}) *FakeBackend {
+	t.Helper()
+
+	return &FakeBackend{
+		Capabilities: capabilities,
+		Responses:    make(map[string]interface{}),
+		t:            t,
+		failures:     newTestFailures(t),
+		changed:      make(chan struct{}),
+		replies:      make(map[string]chan map[string]interface{}),
+	}
+}
+
+// connect returns the proxy side of a new connection to the backend. A
+// restarted backend is connected again, replacing the previous connection.
+func (f *FakeBackend) connect() *JSONRPC {
+	backendIn, proxyOut := io.Pipe()
+	proxyIn, backendOut := io.Pipe()
+	rpc := newJSONRPC(backendIn, backendOut)
+
+	f.mu.Lock()
+	f.rpc = rpc
+	f.mu.Unlock()
+
+	go f.serve(rpc)
+
+	return newJSONRPC(proxyIn, proxyOut)
+}
+
+func (f *FakeBackend) serve(rpc *JSONRPC) {
+	defer rpc.Close()
+
+	for {
+		data, err := rpc.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		messages, err := decodeMessages(data)
+		if err != nil {
+			f.failures.Add("fake backend received invalid message: %s", err)
+
+			return
+		}
+
+		for _, message := range messages {
+			f.handle(message)
+
+			// Like a language server, the fake exits when asked to
+			if message["method"] == "exit" {
+				return
+			}
+		}
+	}
+}
+
+func (f *FakeBackend) handle(message map[string]interface{}) {
+	method, isCall := message["method"].(string)
+	id, hasID := message["id"]
+
+	if !isCall {
+		f.mu.Lock()
+		reply := f.replies[fmt.Sprint(id)]
+		delete(f.replies, fmt.Sprint(id))
+		f.mu.Unlock()
+
+		if reply != nil {
+			reply <- message
+		}
+
+		return
+	}
+
+	f.mu.Lock()
+	f.received = append(f.received, message)
+	close(f.changed)
+	f.changed = make(chan struct{})
+	f.mu.Unlock()
+
+	if !hasID {
+		return
+	}
+
+	if method == "initialize" {
+		if f.Initialize != nil {
+			<-f.Initialize
+		}
+
+		f.send(makeResponse(id, map[string]interface{}{"capabilities": f.Capabilities}))
+
+		return
+	}
+
+	f.send(makeResponse(id, f.Responses[method]))
+}
+
+func (f *FakeBackend) send(message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		f.failures.Add("unable to encode %v: %s", message, err)
+
+		return
+	}
+
+	f.mu.Lock()
+	rpc := f.rpc
+	f.mu.Unlock()
+
+	if err := rpc.SendMessage(data); err != nil {
+		f.failures.Add("fake backend is unable to send: %s", err)
+	}
+}
+
+// Notify sends a notification to the proxy.
+func (f *FakeBackend) Notify(method string, params interface{}) {
+	f.send(makeNotification(method, params))
+}
+
+// SendRaw sends data as the body of a message, valid or not.
+func (f *FakeBackend) SendRaw(data string) {
+	f.mu.Lock()
+	rpc := f.rpc
+	f.mu.Unlock()
+
+	if err := rpc.SendMessage([]byte(data)); err != nil {
+		f.failures.Add("fake backend is unable to send: %s", err)
+	}
+}
+
+// Request sends a request to the proxy and waits for the response.
+func (f *FakeBackend) Request(method string, params interface{}) map[string]interface{} {
+	f.t.Helper()
+
+	reply := make(chan map[string]interface{}, 1)
+
+	f.mu.Lock()
+	f.seq++
+	id := fmt.Sprintf("fake-%d", f.seq)
+	f.replies[id] = reply
+	f.mu.Unlock()
+
+	f.send(makeRequest(id, method, params))
+
+	select {
+	case response := <-reply:
+		return response
+	case <-time.After(testTimeout):
+		f.t.Fatalf("no response to %s", method)
+	}
+
+	return nil
+}
+
+// WaitFor returns all messages received so far once one with method arrived.
+func (f *FakeBackend) WaitFor(method string) []map[string]interface{} {
+	f.t.Helper()
+
+	return f.WaitForCount(method, 1)
+}
+
+// WaitForCount returns all messages received so far once count messages with
+// method arrived.
+func (f *FakeBackend) WaitForCount(method string, count int) []map[string]interface{} {
+	f.t.Helper()
+
+	timeout := time.After(testTimeout)
+
+	for {
+		f.mu.Lock()
+		received := append([]map[string]interface{}{}, f.received...)
+		changed := f.changed
+		f.mu.Unlock()
+
+		found := 0
+
+		for _, message := range received {
+			if message["method"] == method {
+				found++
+			}
+		}
+
+		if found >= count {
+			return received
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			f.t.Fatalf("%s was never received", method)
+		}
+	}
+}
+
+// filterMethod returns the messages with method.
+func filterMethod(messages []map[string]interface{}, method string) []map[string]interface{} {
+	filtered := make([]map[string]interface{}, 0, len(messages))
+
+	for _, message := range messages {
+		if message["method"] == method {
+			filtered = append(filtered, message)
+		}
+	}
+
+	return filtered
+}
+
+// A testEditor drives a Server over in-process connections.
+type testEditor struct {
+	// Answers to requests of the server, by method
+	Responses map[string]interface{}
+
+	t         *testing.T
+	failures  *testFailures
+	rpc       *JSONRPC
+	server    *Server
+	seq       int
+	mu        sync.Mutex
+	responses map[string]chan map[string]interface{}
+	// Notifications and requests of the server not waited for yet
+	notifications []map[string]interface{}
+	notified      chan struct{}
+}
+
+// newTestServer starts a server whose backends are the given fakes, all
+// other backends fail to start.
+func newTestServer(t *testing.T, backends map[string]*FakeBackend) *testEditor {
+	t.Helper()
+
+	return newTestServerWithOptions(t, Options{
+		Backends: func(id string, _ string) (*JSONRPC, error) {
+			backend, ok := backends[id]
+			if !ok {
+				return nil, fmt.Errorf("no fake backend for %s", id)
+			}
+
+			return backend.connect(), nil
+		},
+	})
+}
+
+func newTestServerWithOptions(t *testing.T, options Options) *testEditor {
+	t.Helper()
+
+	serverIn, editorOut := io.Pipe()
+	editorIn, serverOut := io.Pipe()
+
+	server := NewServer(newJSONRPC(serverIn, serverOut), options)
+
+	editor := &testEditor{
+		t:         t,
+		failures:  newTestFailures(t),
+		rpc:       newJSONRPC(editorIn, editorOut),
+		server:    server,
+		Responses: make(map[string]interface{}),
+		responses: make(map[string]chan map[string]interface{}),
+		notified:  make(chan struct{}),
+	}
+
+	go server.Serve()
+	go editor.serve()
+
+	t.Cleanup(func() {
+		_ = editorOut.Close()
+	})
+
+	return editor
+}
+
+func (e *testEditor) serve() {
+	for {
+		data, err := e.rpc.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		messages, err := decodeMessages(data)
+		if err != nil {
+			e.failures.Add("editor received invalid message: %s", err)
+
+			return
+		}
+
+		for _, message := range messages {
+			id, hasID := message["id"]
+			_, isCall := message["method"]
+
+			switch {
+			case isCall && hasID:
+				e.send(makeResponse(id, e.Responses[message["method"].(string)]))
+				e.notify(message)
+			case isCall:
+				e.notify(message)
+			default:
+				e.mu.Lock()
+				response := e.responses[fmt.Sprint(id)]
+				delete(e.responses, fmt.Sprint(id))
+				e.mu.Unlock()
+
+				if response != nil {
+					response <- message
+				}
+			}
+		}
+	}
+}
+
+// notify queues a notification or request of the server, the queue grows so
+// that tests which don't wait for them never block the server.
+func (e *testEditor) notify(message map[string]interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.notifications = append(e.notifications, message)
+	close(e.notified)
+	e.notified = make(chan struct{})
+}
+
+func (e *testEditor) send(message interface{}) {
+	data, _ := json.Marshal(message)
+	if err := e.rpc.SendMessage(data); err != nil {
+		e.failures.Add("editor is unable to send: %s", err)
+	}
+}
+
+func (e *testEditor) Request(method string, params interface{}) map[string]interface{} {
+	e.t.Helper()
+
+	response := make(chan map[string]interface{}, 1)
+
+	e.mu.Lock()
+	e.seq++
+	id := e.seq
+	e.responses[fmt.Sprint(id)] = response
+	e.mu.Unlock()
+
+	e.send(makeRequest(id, method, params))
+
+	select {
+	case message := <-response:
+		return message
+	case <-time.After(testTimeout):
+		e.t.Fatalf("no response to %s", method)
+	}
+
+	return nil
+}
+
+// SendRaw sends data as the body of a message and waits for the response
+// without ID the proxy answers messages it can't decode with.
+func (e *testEditor) SendRaw(data string) map[string]interface{} {
+	e.t.Helper()
+
+	response := make(chan map[string]interface{}, 1)
+
+	e.mu.Lock()
+	e.responses[fmt.Sprint(nil)] = response
+	e.mu.Unlock()
+
+	if err := e.rpc.SendMessage([]byte(data)); err != nil {
+		e.t.Fatalf("editor is unable to send: %s", err)
+	}
+
+	select {
+	case message := <-response:
+		return message
+	case <-time.After(testTimeout):
+		e.t.Fatalf("no response to %q", data)
+	}
+
+	return nil
+}
+
+func (e *testEditor) Notify(method string, params interface{}) {
+	e.send(makeNotification(method, params))
+}
+
+// WaitForNotification skips notifications and requests of the server until
+// one with method matches.
+func (e *testEditor) WaitForNotification(method string, matches func(params map[string]interface{}) bool) map[string]interface{} {
+	e.t.Helper()
+
+	timeout := time.After(testTimeout)
+
+	for {
+		e.mu.Lock()
+		notifications := e.notifications
+		e.notifications = nil
+		notified := e.notified
+		e.mu.Unlock()
+
+		for i, notification := range notifications {
+			params, _ := notification["params"].(map[string]interface{})
+			if notification["method"] == method && matches(params) {
+				e.mu.Lock()
+				e.notifications = append(notifications[i+1:], e.notifications...)
+				e.mu.Unlock()
+
+				return params
+			}
+		}
+
+		select {
+		case <-notified:
+		case <-timeout:
+			e.t.Fatalf("%s was never received", method)
+		}
+	}
+}
+
+// dynamicCommandRegistration lets initialize be answered before backends are
+// ready, an editor without it learns their commands from the answer.
+var dynamicCommandRegistration = map[string]interface{}{
+	"workspace": map[string]interface{}{"executeCommand": map[string]interface{}{"dynamicRegistration": true}},
+}
+
+func (e *testEditor) Initialize() {
+	e.t.Helper()
+
+	e.InitializeWith(map[string]interface{}{})
+}
+
+func (e *testEditor) InitializeWith(capabilities map[string]interface{}) {
+	e.t.Helper()
+
+	response := e.Request("initialize", map[string]interface{}{
+		"rootUri":      "file:///project",
+		"capabilities": capabilities,
+	})
+	if response["error"] != nil {
+		e.t.Fatalf("initialize failed: %v", response["error"])
+	}
+}
+
+func (e *testEditor) Open(uri string, languageID string, text string) {
+	e.Notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        uri,
+			"languageId": languageID,
+			"version":    1,
+			"text":       text,
+		},
+	})
+}
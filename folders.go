@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+// initialWorkspaceFolders returns the folders the editor opened, or the root
+// as the only folder for editors without multi-root support.
+func initialWorkspaceFolders(params protocol.InitializeParams) []protocol.WorkspaceFolder {
+	if params.WorkspaceFolders != nil {
+		return params.WorkspaceFolders
+	}
+
+	if params.RootURI == nil || *params.RootURI == "" {
+		return nil
+	}
+
+	return []protocol.WorkspaceFolder{{URI: *params.RootURI, Name: path.Base(*params.RootURI)}}
+}
+
+// currentWorkspaceFoldersLocked returns the open folders.
+func (s *Server) currentWorkspaceFoldersLocked() []protocol.WorkspaceFolder {
+	if s.workspaceFolders == nil {
+		return nil
+	}
+
+	return append([]protocol.WorkspaceFolder{}, s.workspaceFolders...)
+}
+
+// changeWorkspaceFolders tracks folders the editor opened or closed, and
+// forwards the change to all backends.
+func (s *Server) changeWorkspaceFolders(request map[string]interface{}) error {
+	var params protocol.DidChangeWorkspaceFoldersParams
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &params); err != nil {
+		return fmt.Errorf("invalid workspace/didChangeWorkspaceFolders params: %w", err)
+	}
+
+	removed := make(map[string]bool, len(params.Event.Removed)+len(params.Event.Added))
+	for _, folder := range params.Event.Removed {
+		removed[folder.URI] = true
+	}
+
+	// Added folders replace folders with the same URI
+	for _, folder := range params.Event.Added {
+		removed[folder.URI] = true
+	}
+
+	before := s.settingsSnapshot()
+
+	s.mu.Lock()
+	folders := make([]protocol.WorkspaceFolder, 0, len(s.workspaceFolders)+len(params.Event.Added))
+
+	for _, folder := range s.workspaceFolders {
+		if !removed[folder.URI] {
+			folders = append(folders, folder)
+		}
+	}
+
+	s.workspaceFolders = append(folders, params.Event.Added...)
+	s.mu.Unlock()
+
+	s.broadcast(request)
+	s.watchFiles()
+
+	// The root, and with it the project configuration, may have changed
+	s.loadProject()
+
+	if err := s.syncBackends(s.backendCommands()); err != nil {
+		return err
+	}
+
+	s.notifySettingsChanged(before)
+
+	return nil
+}
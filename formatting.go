@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strconv"
+)
+
+// Requests carrying FormattingOptions.
+var formattingRequests = map[string]bool{
+	"textDocument/formatting":       true,
+	"textDocument/rangeFormatting":  true,
+	"textDocument/rangesFormatting": true,
+	"textDocument/onTypeFormatting": true,
+}
+
+// editorConfigOptions returns the FormattingOptions the .editorconfig files
+// set for a file.
+func editorConfigOptions(path string) map[string]interface{} {
+	properties := editorConfigProperties(path)
+	options := make(map[string]interface{}, len(properties))
+
+	switch properties["indent_style"] {
+	case "space":
+		options["insertSpaces"] = true
+	case "tab":
+		options["insertSpaces"] = false
+	}
+
+	size := properties["indent_size"]
+	if size == "" || size == "tab" || properties["indent_style"] == "tab" && properties["tab_width"] != "" {
+		size = properties["tab_width"]
+	}
+
+	if tabSize, err := strconv.Atoi(size); err == nil && tabSize > 0 {
+		options["tabSize"] = tabSize
+	}
+
+	for property, option := range map[string]string{
+		"trim_trailing_whitespace": "trimTrailingWhitespace",
+		"insert_final_newline":     "insertFinalNewline",
+	} {
+		if value, err := strconv.ParseBool(properties[property]); err == nil {
+			options[option] = value
+		}
+	}
+
+	return options
+}
+
+// formattingOptions returns the FormattingOptions of a document: those of the
+// last formatting request the editor sent for it, overridden by requested and
+// by its .editorconfig.
+func (s *Server) formattingOptions(uri string, requested map[string]interface{}) map[string]interface{} {
+	s.mu.RLock()
+	remembered := s.formatting[uri]
+	s.mu.RUnlock()
+
+	options := make(map[string]interface{}, len(remembered)+len(requested))
+
+	for _, layer := range []map[string]interface{}{remembered, requested, editorConfigOptions(uriPath(uri))} {
+		for key, value := range layer {
+			options[key] = value
+		}
+	}
+
+	return options
+}
+
+// formattingSettings returns the settings of backends describing the
+// indentation of a document.
+func (s *Server) formattingSettings(uri string) map[string]interface{} {
+	if uriPath(uri) == "" {
+		return nil
+	}
+
+	options := s.formattingOptions(uri, nil)
+	xmlFormat := make(map[string]interface{}, len(options))
+	yamlEditor := make(map[string]interface{}, len(options))
+	editor := make(map[string]interface{}, len(options))
+	files := make(map[string]interface{}, len(options))
+
+	if tabSize, ok := options["tabSize"]; ok {
+		xmlFormat["tabSize"] = tabSize
+		yamlEditor["editor.tabSize"] = tabSize
+		editor["tabSize"] = tabSize
+	}
+
+	if insertSpaces, ok := options["insertSpaces"]; ok {
+		xmlFormat["insertSpaces"] = insertSpaces
+		yamlEditor["editor.insertSpace"] = insertSpaces
+		editor["insertSpaces"] = insertSpaces
+	}
+
+	for _, option := range []string{"trimTrailingWhitespace", "insertFinalNewline"} {
+		if value, ok := options[option]; ok {
+			xmlFormat[option] = value
+			files[option] = value
+		}
+	}
+
+	return map[string]interface{}{
+		"xml":    map[string]interface{}{"format": xmlFormat},
+		"[yaml]": yamlEditor,
+		"editor": editor,
+		"files":  files,
+	}
+}
+
+// applyFormattingOptions remembers the FormattingOptions of a formatting
+// request and replaces them by those of the document.
+func (s *Server) applyFormattingOptions(uri string, params map[string]interface{}) {
+	requested, _ := params["options"].(map[string]interface{})
+
+	if requested != nil {
+		s.mu.Lock()
+		s.formatting[uri] = requested
+		s.mu.Unlock()
+	}
+
+	params["options"] = s.formattingOptions(uri, requested)
+}
@@ -3,9 +3,11 @@ module proxy-ls/proxy-ls
 go 1.20
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/hashicorp/go-set v0.1.13
 	github.com/tliron/glsp v0.2.0
 	github.com/withmandala/go-log v0.1.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"github.com/withmandala/go-log"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// Directories that are never watched, they are large and of no interest to
+// any backend. _build and builddir are where meson builds by convention.
+var unwatchedDirectories = map[string]bool{
+	".git":             true,
+	".flatpak-builder": true,
+	"node_modules":     true,
+	"__pycache__":      true,
+	"_build":           true,
+	"builddir":         true,
+}
+
+// An inotifyWatcher watches directory trees and reports the files created,
+// changed and deleted in them.
+type inotifyWatcher struct {
+	logger      *log.Logger
+	fd          int
+	file        *os.File
+	mu          sync.Mutex
+	directories map[int]string
+	closed      bool
+	// Closed once the roots were walked
+	walked chan struct{}
+}
+
+func newInotifyWatcher(roots []string, logger *log.Logger, changed func([]protocol.FileEvent)) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &inotifyWatcher{
+		logger: logger,
+		fd:     fd,
+		// Non-blocking, so Close interrupts reading
+		file:        os.NewFile(uintptr(fd), "inotify"),
+		directories: make(map[int]string, AverageFileCount),
+		walked:      make(chan struct{}),
+	}
+
+	// Large workspaces take a while to walk, changes of directories that
+	// are already watched are reported in the meantime
+	go func() {
+		defer close(w.walked)
+
+		for _, root := range roots {
+			w.addTree(root)
+		}
+
+		w.mu.Lock()
+		count := len(w.directories)
+		w.mu.Unlock()
+
+		logger.Infof("Watching %d directories", count)
+	}()
+
+	go w.run(changed)
+
+	return w, nil
+}
+
+// addTree watches root and all directories below it.
+func (w *inotifyWatcher) addTree(root string) {
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		// Unreadable directories are skipped
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+
+		if path != root && unwatchedDirectories[entry.Name()] {
+			return filepath.SkipDir
+		}
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if w.closed {
+			return filepath.SkipAll
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			// Most likely fs.inotify.max_user_watches was reached
+			return err
+		}
+
+		w.directories[wd] = path
+
+		return nil
+	})
+	if err != nil {
+		w.logger.Warnf("Unable to watch %s: %s", root, err)
+	}
+}
+
+func (w *inotifyWatcher) run(changed func([]protocol.FileEvent)) {
+	buffer := make([]byte, ReadBufferSize)
+
+	for {
+		n, err := w.file.Read(buffer)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.logger.Warnf("Unable to read file events: %s", err)
+			}
+
+			return
+		}
+
+		events := make([]protocol.FileEvent, 0, n/syscall.SizeofInotifyEvent)
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			offset = nameEnd
+
+			if nameEnd > n {
+				break
+			}
+
+			name := string(buffer[nameStart:nameEnd])
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+
+			w.mu.Lock()
+			directory, ok := w.directories[int(event.Wd)]
+
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.directories, int(event.Wd))
+			}
+			w.mu.Unlock()
+
+			if !ok || name == "" {
+				continue
+			}
+
+			path := filepath.Join(directory, name)
+			uri := pathURI(path)
+
+			switch {
+			case event.Mask&syscall.IN_ISDIR != 0:
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && !unwatchedDirectories[name] {
+					w.addTree(path)
+				}
+			case event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+				events = append(events, protocol.FileEvent{URI: uri, Type: protocol.FileChangeTypeCreated})
+			case event.Mask&syscall.IN_CLOSE_WRITE != 0:
+				events = append(events, protocol.FileEvent{URI: uri, Type: protocol.FileChangeTypeChanged})
+			case event.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+				events = append(events, protocol.FileEvent{URI: uri, Type: protocol.FileChangeTypeDeleted})
+			}
+		}
+
+		if len(events) > 0 {
+			changed(events)
+		}
+	}
+}
+
+// Close stops watching. It is safe to call more than once.
+func (w *inotifyWatcher) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		_ = w.file.Close()
+	}
+}
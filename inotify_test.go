@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+func TestBuildDirectoriesAreNotWatched(t *testing.T) {
+	directory := t.TempDir()
+
+	for _, path := range []string{"src", "_build/meson-private", "subproject/builddir"} {
+		if err := os.MkdirAll(filepath.Join(directory, path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := make(chan []protocol.FileEvent, PendingRequestsSize)
+	logger, _ := newLogger(os.Stderr, LogLevelWarn)
+
+	watcher, err := newInotifyWatcher([]string{directory}, logger, func(events []protocol.FileEvent) {
+		changes <- events
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	select {
+	case <-watcher.walked:
+	case <-time.After(testTimeout):
+		t.Fatal("the workspace was never walked")
+	}
+
+	// Created after the walk, the src file last
+	for _, path := range []string{"_build", "subproject/builddir", "_build/meson-private"} {
+		if err := os.WriteFile(filepath.Join(directory, path, "build.ninja"), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(directory, "src", "builddir"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"src/builddir/build.ninja", "src/main.c"} {
+		if err := os.WriteFile(filepath.Join(directory, path), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timeout := time.After(testTimeout)
+
+	for {
+		select {
+		case events := <-changes:
+			for _, event := range events {
+				path := strings.TrimPrefix(event.URI, pathURI(directory))
+				if strings.Contains(path, "build") {
+					t.Errorf("expected build directories not to be watched, got %s", path)
+				}
+
+				if path == "/src/main.c" {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatal("src/main.c was never reported")
+		}
+	}
+}
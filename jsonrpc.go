@@ -1,153 +1,279 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 )
 
+var ErrConnectionClosed = errors.New("connection closed")
+
+// Messages are written by a single goroutine per connection. SendMessage only
+// queues them, so messages from concurrent senders never interleave and each
+// sender's messages keep their order. A full queue blocks the senders.
 type JSONRPC struct {
-	in  io.ReadCloser
-	out io.WriteCloser
+	in             io.ReadCloser
+	reader         *bufio.Reader
+	out            io.WriteCloser
+	MaxMessageSize int
+	queue          chan []byte
+	closed         chan struct{}
+	closeOnce      sync.Once
+	mu             sync.Mutex
+	err            error
+	name           string
+	tracer         *Tracer
+	process        *ProcessIO
 }
 
-func NewJSONRPC() *JSONRPC {
-	realSout, _ := syscall.Dup(syscall.Stdout)
-	checkerror(syscall.Dup2(syscall.Stderr, syscall.Stdout))
+func NewJSONRPC() (*JSONRPC, error) {
+	realSout, err := syscall.Dup(syscall.Stdout)
+	if err != nil {
+		return nil, fmt.Errorf("NewJSONRPC(): unable to duplicate stdout: %w", err)
+	}
 
-	return &JSONRPC{
-		in: os.Stdin,
-		out: &SyscallWriteCloser{
-			fd: realSout,
-		},
+	// Everything else that ends up on stdout would corrupt the stream
+	if err := syscall.Dup2(syscall.Stderr, syscall.Stdout); err != nil {
+		return nil, fmt.Errorf("NewJSONRPC(): unable to redirect stdout: %w", err)
 	}
+
+	return newJSONRPC(os.Stdin, &SyscallWriteCloser{
+		fd: realSout,
+	}), nil
 }
 
-func (rpc *JSONRPC) ReadMessage() ([]byte, error) {
-	var contentLength int
+func newJSONRPC(in io.ReadCloser, out io.WriteCloser) *JSONRPC {
+	rpc := &JSONRPC{
+		in:             in,
+		reader:         bufio.NewReaderSize(in, ReadBufferSize),
+		out:            out,
+		MaxMessageSize: DefaultMaxMessageSize,
+		queue:          make(chan []byte, OutgoingQueueSize),
+		closed:         make(chan struct{}),
+	}
+
+	go rpc.writeMessages()
 
-	var state int
+	return rpc
+}
 
-	// Read headers
-	tmpData := make([]byte, 1)
-	header := ""
-	breakFromLoop := false
+// Trace records all messages of this connection under name. It has to be called
+// before the connection is used.
+func (rpc *JSONRPC) Trace(tracer *Tracer, name string) {
+	rpc.tracer = tracer
+	rpc.name = name
+}
 
+func (rpc *JSONRPC) writeMessages() {
 	for {
-		if breakFromLoop {
-			break
-		}
+		select {
+		case message := <-rpc.queue:
+			if rpc.failure() != nil {
+				continue
+			}
 
-		_, err := rpc.in.Read(tmpData)
-		if err != nil {
-			return nil, err
+			if _, err := rpc.out.Write(message); err != nil {
+				rpc.mu.Lock()
+				rpc.err = fmt.Errorf("error writing message: %w", err)
+				rpc.mu.Unlock()
+			}
+		case <-rpc.closed:
+			return
 		}
+	}
+}
 
-		/*
-		* This is a state machine to parse the headers of a JSON-RPC message in the format:
-		* header: value\r\n
-		* Content-Length: 50\r\n
-		* \r\n
-		* {"jsonrpc": "2.0", ....}
-		 */
-
-		switch tmpData[0] {
-		case '\r':
-			if state == 2 {
-				state = 3
-			} else {
-				state = 1
-			}
-		case '\n':
-			if state == 3 {
-				breakFromLoop = true
+func (rpc *JSONRPC) failure() error {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
 
-				break
-			}
+	return rpc.err
+}
 
-			state = 2
+// Close stops the writer and closes the output. Messages that are still queued
+// are dropped.
+func (rpc *JSONRPC) Close() {
+	rpc.closeOnce.Do(func() {
+		close(rpc.closed)
+		_ = rpc.out.Close()
+	})
+}
 
-			if strings.HasPrefix(header, "Content-Length:") {
-				numberAsStr := strings.TrimSpace(strings.Split(header, ":")[1])
-				contentLength, _ = strconv.Atoi(numberAsStr)
-			}
+/*
+* Reads a message in the format:
+* Content-Length: 50\r\n
+* Content-Type: application/vscode-jsonrpc; charset=utf-8\r\n
+* \r\n
+* {"jsonrpc": "2.0", ....}
+ */
+func (rpc *JSONRPC) ReadMessage() ([]byte, error) {
+	contentLength, err := rpc.readHeaders()
+	if err != nil {
+		return nil, err
+	}
 
-			header = ""
-		default:
-			header += string(tmpData)
-			state = 5
-		}
+	messageData := make([]byte, contentLength)
+
+	n, err := io.ReadFull(rpc.reader, messageData)
+	if err != nil {
+		return nil, fmt.Errorf("ReadMessage(): error reading %d bytes (Read %d): %w", contentLength, n, err)
 	}
 
-	// Read JSON-RPC message
-	messageData := make([]byte, 0)
+	rpc.tracer.Record(TraceReceived, rpc.name, messageData)
+
+	return messageData, nil
+}
 
-	left := contentLength
+func (rpc *JSONRPC) readHeaders() (int, error) {
+	contentLength := -1
+	headerSize := 0
 
 	for {
-		if left == 0 {
+		line, err := rpc.reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return 0, fmt.Errorf("readHeaders(): header line exceeds %d bytes", ReadBufferSize)
+		} else if err != nil {
+			return 0, err
+		}
+
+		headerSize += len(line)
+		if headerSize > MaxHeaderSize {
+			return 0, fmt.Errorf("readHeaders(): headers exceed %d bytes", MaxHeaderSize)
+		}
+
+		if !bytes.HasSuffix(line, []byte("\r\n")) {
+			return 0, fmt.Errorf("readHeaders(): header %q is not terminated by \\r\\n", line)
+		}
+
+		header := string(line[:len(line)-2])
+		if header == "" {
 			break
 		}
 
-		amount := 1000
-		if amount > left {
-			amount = left
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return 0, fmt.Errorf("readHeaders(): malformed header %q", header)
 		}
 
-		tmp := make([]byte, amount)
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			if contentLength != -1 {
+				return 0, fmt.Errorf("readHeaders(): duplicate Content-Length header")
+			}
+
+			contentLength, err = strconv.Atoi(value)
+			if err != nil || contentLength <= 0 {
+				return 0, fmt.Errorf("readHeaders(): invalid Content-Length %q", value)
+			}
 
-		n, err := rpc.in.Read(tmp)
-		if err != nil {
-			return nil, fmt.Errorf("ReadMessage(): error reading message data: %w", err)
+			if contentLength > rpc.MaxMessageSize {
+				return 0, fmt.Errorf("readHeaders(): message of %d bytes exceeds the maximum of %d bytes", contentLength, rpc.MaxMessageSize)
+			}
+		case "content-type":
+			if err := checkContentType(value); err != nil {
+				return 0, err
+			}
 		}
+	}
 
-		messageData = append(messageData, tmp[:n]...)
-		left -= n
+	if contentLength == -1 {
+		return 0, fmt.Errorf("readHeaders(): missing Content-Length header")
 	}
 
-	for {
-		if messageData[len(messageData)-1] != 0 {
-			break
+	return contentLength, nil
+}
+
+func checkContentType(value string) error {
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return fmt.Errorf("checkContentType(): invalid Content-Type %q: %w", value, err)
+	}
+
+	// "utf8" is accepted for backwards compatibility, just like vscode-jsonrpc does
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "utf8") {
+		return fmt.Errorf("checkContentType(): unsupported charset %q", charset)
+	}
+
+	return nil
+}
+
+// decodeMessages decodes a single message or a batch of messages. Invalid
+// JSON fails with ParseError, JSON that isn't a message with InvalidRequest.
+func decodeMessages(data []byte) ([]map[string]interface{}, error) {
+	if !json.Valid(data) {
+		return nil, newResponseError(ParseError, "Parse error: invalid JSON")
+	}
+
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []map[string]interface{}
+		if err := json.Unmarshal(trimmed, &batch); err != nil {
+			return nil, newResponseError(InvalidRequest, "Invalid batch: %s", err)
 		}
 
-		messageData = messageData[:len(messageData)-1]
+		if len(batch) == 0 {
+			return nil, newResponseError(InvalidRequest, "Empty batch")
+		}
+
+		return batch, nil
 	}
 
-	if len(messageData) != contentLength {
-		return nil, fmt.Errorf("ReadMessage(): error reading %d bytes. (Read %d)", contentLength, len(messageData))
+	var message map[string]interface{}
+	if err := json.Unmarshal(data, &message); err != nil || message == nil {
+		return nil, newResponseError(InvalidRequest, "Invalid message: %v", err)
 	}
 
-	return messageData, nil
+	return []map[string]interface{}{message}, nil
 }
 
 func (rpc *JSONRPC) SendMessage(message []byte) error {
 	if string(message) == "null" {
-		panic(message)
+		return fmt.Errorf("SendMessage(): refusing to send null")
+	}
+
+	if err := rpc.failure(); err != nil {
+		return err
+	}
+
+	// A select with both ready may pick the queue after Close
+	select {
+	case <-rpc.closed:
+		return ErrConnectionClosed
+	default:
 	}
 
+	rpc.tracer.Record(TraceSent, rpc.name, message)
+
 	contentLength := len(message)
 	headers := fmt.Sprintf("Content-Length: %d\r\n\r\n", contentLength)
 
-	// Write headers and JSON-RPC message
-	_, err := rpc.out.Write([]byte(headers))
-	if err != nil {
-		return fmt.Errorf("error writing headers: %w", err)
+	select {
+	case rpc.queue <- append([]byte(headers), message...):
+		return nil
+	case <-rpc.closed:
+		return ErrConnectionClosed
 	}
+}
 
-	_, err = rpc.out.Write(message)
-	if err != nil {
-		return fmt.Errorf("error writing message: %w", err)
-	}
+func jsonrpcFromProcessIO(p *ProcessIO) *JSONRPC {
+	rpc := newJSONRPC(p.stdout, p.stdin)
+	rpc.process = p
 
-	return nil
+	return rpc
 }
 
-func jsonrpcFromProcessIO(p *ProcessIO) *JSONRPC {
-	return &JSONRPC{
-		in:  p.stdout,
-		out: p.stdin,
-	}
+// Process returns the process on the other end, if there is one.
+func (rpc *JSONRPC) Process() *ProcessIO {
+	return rpc.process
 }
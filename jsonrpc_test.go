@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type discardCloser struct{}
+
+func (discardCloser) Write(data []byte) (int, error) { return len(data), nil }
+
+func (discardCloser) Close() error { return nil }
+
+func readerRPC(input string) *JSONRPC {
+	rpc := newJSONRPC(io.NopCloser(strings.NewReader(input)), discardCloser{})
+	rpc.MaxMessageSize = 100
+
+	return rpc
+}
+
+func TestReadHeaders(t *testing.T) {
+	for _, test := range []struct {
+		input  string
+		length int
+		fails  bool
+	}{
+		{input: "Content-Length: 12\r\n\r\n", length: 12},
+		{input: "content-length:12\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n", length: 12},
+		{input: "Content-Length: 12\r\nContent-Type: application/vscode-jsonrpc; charset=utf8\r\n\r\n", length: 12},
+		{input: "Content-Length: 12\r\nX-Unknown: ignored\r\n\r\n", length: 12},
+		{input: "Content-Length: 12\n\n", fails: true},
+		{input: "Content-Type: application/vscode-jsonrpc\r\n\r\n", fails: true},
+		{input: "Content-Length: 12\r\nContent-Length: 12\r\n\r\n", fails: true},
+		{input: "Content-Length: -1\r\n\r\n", fails: true},
+		{input: "Content-Length: 0\r\n\r\n", fails: true},
+		{input: "Content-Length: twelve\r\n\r\n", fails: true},
+		{input: "Content-Length: 101\r\n\r\n", fails: true},
+		{input: "Content-Length 12\r\n\r\n", fails: true},
+		{input: "Content-Length: 12\r\nContent-Type: text/plain; charset=latin1\r\n\r\n", fails: true},
+		{input: "Content-Length: 12\r\nX-Padding: " + strings.Repeat("x", MaxHeaderSize) + "\r\n\r\n", fails: true},
+		{input: "Content-Length: 12\r\n", fails: true},
+	} {
+		length, err := readerRPC(test.input).readHeaders()
+		if test.fails && err == nil {
+			t.Errorf("expected %q to be rejected, got length %d", test.input, length)
+		} else if !test.fails && (err != nil || length != test.length) {
+			t.Errorf("expected length %d for %q, got %d (%v)", test.length, test.input, length, err)
+		}
+	}
+}
+
+func TestCheckContentType(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"application/vscode-jsonrpc":                 true,
+		"application/vscode-jsonrpc; charset=utf-8":  true,
+		"application/vscode-jsonrpc; charset=UTF-8":  true,
+		"application/vscode-jsonrpc; charset=utf8":   true,
+		"application/vscode-jsonrpc; charset=utf-16": false,
+		"application/vscode-jsonrpc; charset":        false,
+		"":                                           false,
+	} {
+		if err := checkContentType(value); (err == nil) != valid {
+			t.Errorf("expected %q to be valid: %v, got %v", value, valid, err)
+		}
+	}
+}
+
+func TestDecodeMessages(t *testing.T) {
+	for _, test := range []struct {
+		data  string
+		count int
+		// The code of the error, if the data is rejected
+		code int
+	}{
+		{data: `{"jsonrpc": "2.0", "method": "initialized"}`, count: 1},
+		{data: " \n[{\"id\": 1, \"method\": \"a\"}, {\"method\": \"b\"}]", count: 2},
+		{data: `[]`, code: InvalidRequest},
+		{data: `[1, 2]`, code: InvalidRequest},
+		{data: `{"jsonrpc": `, code: ParseError},
+		{data: `"initialized"`, code: InvalidRequest},
+		{data: `null`, code: InvalidRequest},
+	} {
+		messages, err := decodeMessages([]byte(test.data))
+
+		var responseError *ResponseError
+		if test.code != 0 && (!errors.As(err, &responseError) || responseError.Code != test.code) {
+			t.Errorf("expected %q to be rejected with %d, got %v (%v)", test.data, test.code, messages, err)
+		} else if test.code == 0 && (err != nil || len(messages) != test.count) {
+			t.Errorf("expected %d messages in %q, got %v (%v)", test.count, test.data, messages, err)
+		}
+	}
+}
+
+func TestReadMessageRejectsOversizedMessages(t *testing.T) {
+	body := `{"jsonrpc": "2.0", "method": "initialized"}`
+	rpc := readerRPC("Content-Length: 43\r\n\r\n" + body + "Content-Length: 101\r\n\r\n" + strings.Repeat(" ", 101))
+
+	if data, err := rpc.ReadMessage(); err != nil || string(data) != body {
+		t.Fatalf("expected the first message, got %q (%v)", data, err)
+	}
+
+	if _, err := rpc.ReadMessage(); err == nil || !strings.Contains(err.Error(), "exceeds the maximum") {
+		t.Errorf("expected the oversized message to be rejected, got %v", err)
+	}
+}
+
+func TestConcurrentSendersKeepTheirOrder(t *testing.T) {
+	in, out := io.Pipe()
+	sender := newJSONRPC(io.NopCloser(strings.NewReader("")), out)
+	receiver := newJSONRPC(in, discardCloser{})
+
+	const senders, messages = 8, 50
+
+	var sending sync.WaitGroup
+
+	for i := 0; i < senders; i++ {
+		sending.Add(1)
+
+		go func(i int) {
+			defer sending.Done()
+
+			for j := 0; j < messages; j++ {
+				if err := sender.SendMessage([]byte(fmt.Sprintf(`{"sender":%d,"seq":%d}`, i, j))); err != nil {
+					t.Errorf("unable to send: %s", err)
+				}
+			}
+		}(i)
+	}
+
+	next := make([]int, senders)
+
+	for received := 0; received < senders*messages; received++ {
+		data, err := receiver.ReadMessage()
+		if err != nil {
+			t.Fatalf("unable to read: %s", err)
+		}
+
+		var message struct {
+			Sender int `json:"sender"`
+			Seq    int `json:"seq"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("interleaved message %q: %s", data, err)
+		}
+
+		if message.Seq != next[message.Sender] {
+			t.Errorf("sender %d: expected message %d, got %d", message.Sender, next[message.Sender], message.Seq)
+		}
+
+		next[message.Sender] = message.Seq + 1
+	}
+
+	sending.Wait()
+	sender.Close()
+}
+
+func TestMessagesAreRefusedAfterClose(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		rpc := newJSONRPC(io.NopCloser(strings.NewReader("")), discardCloser{})
+		rpc.Close()
+
+		if err := rpc.SendMessage([]byte("{}")); !errors.Is(err, ErrConnectionClosed) {
+			t.Fatalf("expected ErrConnectionClosed, got %v", err)
+		}
+	}
+}
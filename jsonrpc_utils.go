@@ -1,5 +1,10 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+)
+
 func makeNotification(method string, params any) map[string]interface{} {
 	return map[string]interface{}{
 		"jsonrpc": "2.0",
@@ -24,3 +29,34 @@ func makeRequest(seq any, method string, params any) map[string]interface{} {
 		"params":  params,
 	}
 }
+
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newResponseError(code int, format string, args ...any) *ResponseError {
+	return &ResponseError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// makeErrorResponse reports err to the sender of request seq. Errors that are
+// not a ResponseError are reported as InternalError.
+func makeErrorResponse(seq any, err error) map[string]interface{} {
+	var responseError *ResponseError
+	if !errors.As(err, &responseError) {
+		responseError = newResponseError(InternalError, "%s", err)
+	}
+
+	return map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      seq,
+		"error":   responseError,
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/withmandala/go-log"
+)
+
+const (
+	LogLevelError = iota
+	LogLevelWarn
+	LogLevelInfo
+	LogLevelDebug
+)
+
+var logLevelNames = map[string]int{
+	"error": LogLevelError,
+	"warn":  LogLevelWarn,
+	"info":  LogLevelInfo,
+	"debug": LogLevelDebug,
+}
+
+func logLevelName(level int) string {
+	for name, value := range logLevelNames {
+		if value == level {
+			return name
+		}
+	}
+
+	return fmt.Sprint(level)
+}
+
+func parseLogLevel(level string) (int, error) {
+	if parsed, ok := logLevelNames[strings.ToLower(level)]; ok {
+		return parsed, nil
+	}
+
+	return 0, fmt.Errorf("unknown log level %q, expected error, warn, info or debug", level)
+}
+
+// levelWriter drops the lines of go-log below a level, as go-log itself only
+// knows whether debug messages are enabled.
+type levelWriter struct {
+	out   log.FdWriter
+	level atomic.Int32
+}
+
+func (w *levelWriter) Write(data []byte) (int, error) {
+	// The prefix is the first thing on a line, possibly colored
+	head := data
+	if len(head) > 16 {
+		head = head[:16]
+	}
+
+	level := int(w.level.Load())
+
+	switch {
+	case bytes.Contains(head, []byte("[WARN]")) && level < LogLevelWarn,
+		bytes.Contains(head, []byte("[INFO]")) && level < LogLevelInfo:
+		return len(data), nil
+	}
+
+	return w.out.Write(data)
+}
+
+func (w *levelWriter) Fd() uintptr {
+	return w.out.Fd()
+}
+
+// newLogger returns a logger writing to out and the writer to change its
+// level with.
+func newLogger(out log.FdWriter, level int) (*log.Logger, *levelWriter) {
+	writer := &levelWriter{out: out}
+	logger := log.New(writer)
+	writer.setLevel(logger, level)
+
+	return logger, writer
+}
+
+// setLevel changes the level of the logger writing to w.
+func (w *levelWriter) setLevel(logger *log.Logger, level int) {
+	w.level.Store(int32(level))
+
+	if level >= LogLevelDebug {
+		logger.WithDebug()
+	} else {
+		logger.WithoutDebug()
+	}
+}
+
+// stateDirectory is where log files are kept, $XDG_STATE_HOME/proxy-ls.
+func stateDirectory() string {
+	directory := xdgDirectory("XDG_STATE_HOME", filepath.Join(".local", "state"))
+	if directory == "" {
+		return ""
+	}
+
+	return filepath.Join(directory, "proxy-ls")
+}
+
+// rotatingFile is a log file that is moved to path.1 once it reaches
+// MaxLogFileSize. Older files are moved up to path.LogFileCount.
+type rotatingFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("openRotatingFile(): %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("openRotatingFile(): %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return nil, fmt.Errorf("openRotatingFile(): %w", err)
+	}
+
+	return &rotatingFile{path: path, file: file, size: info.Size()}, nil
+}
+
+func (f *rotatingFile) Write(data []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.size+int64(len(data)) > MaxLogFileSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	_ = f.file.Close()
+
+	for i := LogFileCount - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+
+	_ = os.Rename(f.path, f.path+".1")
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("rotate(): %w", err)
+	}
+
+	f.file = file
+	f.size = 0
+
+	return nil
+}
+
+func (f *rotatingFile) Fd() uintptr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Fd()
+}
+
+// teeWriter writes to several outputs. Its file descriptor is the one of the
+// first, which decides whether go-log uses colors.
+type teeWriter struct {
+	outputs []log.FdWriter
+}
+
+func (w *teeWriter) Write(data []byte) (int, error) {
+	for _, out := range w.outputs {
+		_, _ = out.Write(data)
+	}
+
+	return len(data), nil
+}
+
+func (w *teeWriter) Fd() uintptr {
+	return w.outputs[0].Fd()
+}
+
+// logOutput combines out with the rotating log file name in directory, if
+// there is a directory and the file can be opened.
+func logOutput(out log.FdWriter, directory string, name string) log.FdWriter {
+	if directory == "" {
+		return out
+	}
+
+	file, err := openRotatingFile(filepath.Join(directory, name+".log"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open log file: %s\n", err)
+
+		return out
+	}
+
+	return &teeWriter{outputs: []log.FdWriter{out, file}}
+}
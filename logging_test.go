@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLogFilesAreRotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xml.log")
+
+	file, err := openRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := []byte(strings.Repeat("x", MaxLogFileSize/2) + "\n")
+	for i := 0; i < 2*LogFileCount+2; i++ {
+		if _, err := file.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("expected a rotated file: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "xml.log.4")); err == nil {
+		t.Errorf("expected at most %d old files", LogFileCount)
+	}
+}
+
+func TestStderrIsSplitIntoLines(t *testing.T) {
+	lines := make([]string, 0)
+	writer := &lineWriter{line: func(line string) {
+		lines = append(lines, line)
+	}}
+
+	_, _ = writer.Write([]byte("first\r\nsec"))
+	_, _ = writer.Write([]byte("ond\nthird"))
+
+	if strings.Join(lines, "|") != "first|second" {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
+
+func TestLogLinesAreFilteredByLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy-ls.log")
+
+	file, err := openRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, _ := newLogger(file, LogLevelWarn)
+	logger.Infof("hidden")
+	logger.Warnf("shown")
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "hidden") || !strings.Contains(string(data), "shown") {
+		t.Errorf("unexpected log %q", data)
+	}
+}
+
+func TestLogLevelCanBeChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy-ls.log")
+
+	file, err := openRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, writer := newLogger(file, LogLevelInfo)
+	writer.setLevel(logger, LogLevelError)
+	logger.Warnf("hidden")
+	writer.setLevel(logger, LogLevelDebug)
+	logger.Debugf("shown")
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "hidden") || !strings.Contains(string(data), "shown") {
+		t.Errorf("unexpected log %q", data)
+	}
+}
+
+func TestBackendLoggersAreSafeDuringStartup(t *testing.T) {
+	out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	var (
+		editor  *testEditor
+		logging sync.WaitGroup
+	)
+
+	started := make(chan struct{})
+	options := Options{
+		LogOutput: out,
+		// Every backend logs from its own goroutine, like the readers of stderr
+		Backends: func(id string, _ string) (*JSONRPC, error) {
+			logging.Add(1)
+
+			go func() {
+				defer logging.Done()
+
+				<-started
+
+				for i := 0; i < 100; i++ {
+					editor.server.backendLogger(id).Infof("line %d", i)
+				}
+			}()
+
+			return nil, errors.New("not installed")
+		},
+	}
+	options.Reload = func() (Options, error) {
+		return options, nil
+	}
+
+	editor = newTestServerWithOptions(t, options)
+	close(started)
+	editor.Initialize()
+	editor.Request("workspace/executeCommand", map[string]interface{}{"command": ReloadConfigCommand})
+	logging.Wait()
+}
+
+func TestEditorChangesTheLogLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy-ls.log")
+
+	file, err := openRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+	logger, writer := newLogger(file, LogLevelWarn)
+	editor := newTestServerWithOptions(t, Options{
+		Logger:         logger,
+		LogLevelWriter: writer,
+		LogLevel:       LogLevelWarn,
+		Backends: func(id string, _ string) (*JSONRPC, error) {
+			if id != "json" {
+				return nil, errors.New("not installed")
+			}
+
+			return json.connect(), nil
+		},
+	})
+	editor.Request("initialize", map[string]interface{}{
+		"rootUri":               "file:///project",
+		"capabilities":          map[string]interface{}{},
+		"initializationOptions": map[string]interface{}{"proxyLs": map[string]interface{}{"logLevel": "info"}},
+	})
+	json.WaitFor("initialized")
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "Log level set to info by the editor") {
+		t.Errorf("expected the level to be logged by name, got %q", data)
+	}
+
+	if writer.level.Load() != LogLevelInfo {
+		t.Errorf("expected the level of the editor, got %d", writer.level.Load())
+	}
+}
+
+func TestReloadAppliesTheLogLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy-ls.log")
+
+	file, err := openRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := LogLevelDebug
+	logger, writer := newLogger(file, LogLevelWarn)
+	editor := newTestServerWithOptions(t, Options{
+		Logger:         logger,
+		LogLevelWriter: writer,
+		LogLevel:       LogLevelWarn,
+		Reload: func() (Options, error) {
+			return Options{LogLevel: reloaded}, nil
+		},
+		Backends: func(id string, _ string) (*JSONRPC, error) {
+			return nil, errors.New("not installed")
+		},
+	})
+	editor.Initialize()
+
+	reload := func() {
+		t.Helper()
+
+		response := editor.Request("workspace/executeCommand", map[string]interface{}{"command": ReloadConfigCommand})
+		if response["error"] != nil {
+			t.Fatalf("unable to reload: %v", response["error"])
+		}
+	}
+
+	reload()
+
+	if writer.level.Load() != LogLevelDebug {
+		t.Errorf("expected the reloaded level, got %d", writer.level.Load())
+	}
+
+	// Settings without proxyLs keep the level the editor chose
+	editor.Notify("workspace/didChangeConfiguration", map[string]interface{}{
+		"settings": map[string]interface{}{"proxyLs": map[string]interface{}{"logLevel": "error"}},
+	})
+	editor.Notify("workspace/didChangeConfiguration", map[string]interface{}{
+		"settings": map[string]interface{}{"yaml": map[string]interface{}{}},
+	})
+
+	reloaded = LogLevelInfo
+	reload()
+
+	if writer.level.Load() != LogLevelError {
+		t.Errorf("expected the level of the editor, got %d", writer.level.Load())
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "Log level set to debug by the configuration") {
+		t.Errorf("expected the reloaded level to be logged, got %q", data)
+	}
+}
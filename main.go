@@ -1,7 +1,369 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/withmandala/go-log"
+)
+
+// backendCommands collects repeated --backend id=command flags.
+type backendCommands map[string]string
+
+func (b backendCommands) String() string {
+	return fmt.Sprint(map[string]string(b))
+}
+
+func (b backendCommands) Set(value string) error {
+	id, command, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected <backend>=<command>, got %q", value)
+	}
+
+	if _, err := str2int(id); err != nil {
+		return err
+	}
+
+	b[id] = command
+
+	return nil
+}
+
+// backendList collects repeated --enable and --disable flags.
+type backendList []string
+
+func (b *backendList) String() string {
+	return strings.Join(*b, ",")
+}
+
+func (b *backendList) Set(value string) error {
+	if _, err := str2int(value); err != nil {
+		return err
+	}
+
+	*b = append(*b, value)
+
+	return nil
+}
+
+// logLevels collects repeated --log-level flags, either <level> for the proxy
+// or <backend>=<level>.
+type logLevels map[string]string
+
+func (l logLevels) String() string {
+	return fmt.Sprint(map[string]string(l))
+}
+
+func (l logLevels) Set(value string) error {
+	id, level, ok := strings.Cut(value, "=")
+	if !ok {
+		id, level = "", value
+	} else if _, err := str2int(id); err != nil {
+		return err
+	}
+
+	if _, err := parseLogLevel(level); err != nil {
+		return err
+	}
+
+	l[id] = level
+
+	return nil
+}
+
+type flags struct {
+	set        *flag.FlagSet
+	config     string
+	logLevels  logLevels
+	logFile    string
+	traceFile  string
+	enable     backendList
+	disable    backendList
+	commands   backendCommands
+	version    bool
+	stdio      bool
+	socket     int
+	pipe       string
+	jsonOutput bool
+	network    bool
+	// Where log files are kept, empty to keep none
+	logDirectory   string
+	timeout        time.Duration
+	maxMessageSize int
+}
+
+func parseFlags(args []string) *flags {
+	f := &flags{
+		set:       flag.NewFlagSet("proxy-ls", flag.ExitOnError),
+		commands:  backendCommands{},
+		logLevels: logLevels{},
+	}
+	f.set.StringVar(&f.config, "config", "", "read the configuration from this file instead of $XDG_CONFIG_HOME/proxy-ls/config.json")
+	f.set.Var(f.logLevels, "log-level", "log messages up to this level: error, warn, info or debug (default info), <backend>=<level> sets the level of a backend")
+	f.set.StringVar(&f.logFile, "log-file", "", "append the log to this file instead of writing it to stderr, logs are also kept in $XDG_STATE_HOME/proxy-ls")
+	f.set.StringVar(&f.traceFile, "trace-file", "", "write every message on every connection to this file as JSONL")
+	f.set.Var(&f.enable, "enable", "enable a backend disabled in the configuration, can be repeated")
+	f.set.Var(&f.disable, "disable", "don't start a backend, can be repeated")
+	f.set.Var(f.commands, "backend", "start a backend with another command, e.g. --backend \"json=node server.js --stdio\"")
+	f.set.BoolVar(&f.version, "version", false, "print the version and exit")
+	f.set.BoolVar(&f.stdio, "stdio", false, "talk to the editor over stdin and stdout (default)")
+	f.set.IntVar(&f.socket, "socket", 0, "talk to the editor over a TCP connection to this port on localhost")
+	f.set.StringVar(&f.pipe, "pipe", "", "talk to the editor over a connection to this unix socket")
+	f.set.IntVar(&f.maxMessageSize, "max-message-size", 0, "reject messages larger than this many bytes (default 64 MiB)")
+	f.set.BoolVar(&f.jsonOutput, "json", false, "doctor: print the report as JSON")
+	f.set.BoolVar(&f.network, "network", false, "doctor: also check that remote schemas without a local copy can be downloaded")
+	f.set.DurationVar(&f.timeout, "timeout", InitializeTimeout, "doctor: how long to wait for each check")
+	f.logDirectory = stateDirectory()
+	f.set.Usage = func() {
+		fmt.Fprintf(f.set.Output(), "Usage: proxy-ls [options]\n       proxy-ls replay [options] <trace>\n       proxy-ls doctor [options]\n")
+		f.set.PrintDefaults()
+	}
+	_ = f.set.Parse(args)
+
+	return f
+}
+
+// backendOptions combines the configuration of the backends in the
+// configuration file with the flags, which take precedence.
+func (f *flags) backendOptions() (Options, Config, error) {
+	path := f.config
+	if path == "" {
+		path = defaultConfigPath()
+	}
+
+	config, err := LoadConfig(path, f.config != "")
+	if err != nil {
+		return Options{}, config, err
+	}
+
+	options := Options{
+		Commands:         make(map[string]string, len(config.Backends)+len(f.commands)),
+		Disabled:         make(map[string]bool, len(config.Disabled)+len(f.disable)),
+		LogDirectory:     f.logDirectory,
+		BackendLogLevels: make(map[string]int, len(config.Backends)+len(f.logLevels)),
+		MessageLevels:    make(map[string]int, len(config.Backends)),
+		Settings:         config.Settings,
+		Schemas:          config.Schemas,
+		Scopes:           config.Scopes,
+		Formatters:       config.Formatters,
+		MaxMessageSize:   config.MaxMessageSize,
+	}
+
+	if f.maxMessageSize != 0 {
+		options.MaxMessageSize = f.maxMessageSize
+	}
+
+	if options.MaxMessageSize < 0 {
+		return options, config, fmt.Errorf("invalid maximum message size %d", options.MaxMessageSize)
+	}
+	levels := make(map[string]string, len(config.Backends)+len(f.logLevels))
+
+	for id, backend := range config.Backends {
+		if backend.Command != "" {
+			options.Commands[id] = backend.Command
+		}
+
+		if backend.LogLevel != "" {
+			levels[id] = backend.LogLevel
+		}
+
+		if backend.MessageLevel != "" {
+			if options.MessageLevels[id], err = parseLogLevel(backend.MessageLevel); err != nil {
+				return options, config, err
+			}
+		}
+	}
+
+	for id, command := range f.commands {
+		options.Commands[id] = command
+	}
+
+	for _, id := range append(config.Disabled, f.disable...) {
+		options.Disabled[id] = true
+	}
+
+	for _, id := range append(config.Enabled, f.enable...) {
+		delete(options.Disabled, id)
+	}
+
+	for id, level := range f.logLevels {
+		levels[id] = level
+	}
+
+	for id, name := range levels {
+		if id == "" {
+			continue
+		}
+
+		if options.BackendLogLevels[id], err = parseLogLevel(name); err != nil {
+			return options, config, err
+		}
+	}
+
+	// Computed here, so that reloading the configuration applies it again
+	levelName, ok := levels[""]
+	if !ok {
+		levelName = config.LogLevel
+	}
+
+	options.LogLevel = LogLevelInfo
+	if levelName != "" {
+		if options.LogLevel, err = parseLogLevel(levelName); err != nil {
+			return options, config, err
+		}
+	}
+
+	return options, config, nil
+}
+
+// options combines the configuration file with the flags and opens the log
+// and the trace.
+func (f *flags) options() (Options, error) {
+	options, config, err := f.backendOptions()
+	if err != nil {
+		return options, err
+	}
+
+	options.Reload = func() (Options, error) {
+		reloaded, _, err := f.backendOptions()
+
+		return reloaded, err
+	}
+
+	logFile := f.logFile
+	if logFile == "" {
+		logFile = config.LogFile
+	}
+
+	var out log.FdWriter = os.Stderr
+	if logFile != "" {
+		if out, err = os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+			return options, fmt.Errorf("unable to open the log file: %w", err)
+		}
+	}
+
+	options.LogOutput = logOutput(out, options.LogDirectory, "proxy-ls")
+	options.Logger, options.LogLevelWriter = newLogger(options.LogOutput, options.LogLevel)
+
+	traceFile := f.traceFile
+	if traceFile == "" {
+		traceFile = config.TraceFile
+	}
+
+	if traceFile != "" {
+		if options.Tracer, err = NewTracer(traceFile); err != nil {
+			return options, err
+		}
+	}
+
+	return options, nil
+}
+
+// connect opens the connection to the editor on the selected transport.
+func (f *flags) connect() (*JSONRPC, error) {
+	selected := 0
+
+	for _, isSelected := range []bool{f.stdio, f.socket != 0, f.pipe != ""} {
+		if isSelected {
+			selected++
+		}
+	}
+
+	if selected > 1 {
+		return nil, fmt.Errorf("only one of --stdio, --socket and --pipe can be used")
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	switch {
+	case f.socket != 0:
+		conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(f.socket)))
+	case f.pipe != "":
+		conn, err = net.Dial("unix", f.pipe)
+	default:
+		return NewJSONRPC()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the editor: %w", err)
+	}
+
+	return newJSONRPC(conn, conn), nil
+}
+
 func main() {
-	rpc := NewJSONRPC()
-	server := NewServer(rpc)
+	logger := log.New(os.Stderr)
+	args := os.Args[1:]
+	subcommand := ""
+
+	if len(args) > 0 && (args[0] == "replay" || args[0] == "doctor") {
+		subcommand = args[0]
+		args = args[1:]
+	}
+
+	f := parseFlags(args)
+	if f.version {
+		fmt.Printf("proxy-ls %s\n", Version)
+
+		return
+	}
+
+	options, err := f.options()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	logger = options.Logger
+
+	switch subcommand {
+	case "doctor":
+		report := RunDoctor(options, f.timeout, f.network)
+		if f.jsonOutput {
+			_ = report.WriteJSON(os.Stdout)
+		} else {
+			report.WriteText(os.Stdout)
+		}
+
+		if !report.OK() {
+			os.Exit(1)
+		}
+
+		return
+	case "replay":
+		if f.set.NArg() != 1 {
+			f.set.Usage()
+			os.Exit(2)
+		}
+
+		entries, err := ReadTrace(f.set.Arg(0))
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		err = Replay(entries, options, os.Stdout)
+		_ = options.Tracer.Close()
+
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		return
+	}
+
+	rpc, err := f.connect()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	server := NewServer(rpc, options)
 	server.Serve()
+	_ = options.Tracer.Close()
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlagsTakePrecedenceOverConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	config := `{"maxMessageSize": 1024, "disabled": ["xml", "yaml"], "backends": {"json": {"command": "json-from-config"}, "toml": {"command": "toml-from-config"}}}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := parseFlags([]string{"--config", path, "--enable", "yaml", "--disable", "bash", "--backend", "json=json-from-flag", "--log-level", "xml=debug", "--max-message-size", "2048"})
+	f.logDirectory = ""
+
+	options, err := f.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !options.Disabled["xml"] || options.Disabled["yaml"] || !options.Disabled["bash"] {
+		t.Errorf("unexpected disabled backends %v", options.Disabled)
+	}
+
+	if options.Commands["json"] != "json-from-flag" || options.Commands["toml"] != "toml-from-config" {
+		t.Errorf("unexpected commands %v", options.Commands)
+	}
+
+	if level, ok := options.BackendLogLevels["xml"]; !ok || level != LogLevelDebug {
+		t.Errorf("unexpected log levels %v", options.BackendLogLevels)
+	}
+
+	if options.MaxMessageSize != 2048 {
+		t.Errorf("unexpected maximum message size %d", options.MaxMessageSize)
+	}
+}
+
+func TestExplicitConfigMustExist(t *testing.T) {
+	f := parseFlags([]string{"--config", filepath.Join(t.TempDir(), "missing.json")})
+	f.logDirectory = ""
+
+	if _, err := f.options(); err == nil {
+		t.Error("expected an error for a missing configuration")
+	}
+}
+
+func TestLogLevelIsReloaded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"logLevel": "warn"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		args     []string
+		expected []int
+	}{
+		{[]string{"--config", path}, []int{LogLevelWarn, LogLevelDebug}},
+		{[]string{"--config", path, "--log-level", "error"}, []int{LogLevelError, LogLevelError}},
+	} {
+		if err := os.WriteFile(path, []byte(`{"logLevel": "warn"}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		f := parseFlags(test.args)
+		f.logDirectory = ""
+
+		options, err := f.options()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(`{"logLevel": "debug"}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := options.Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if options.LogLevel != test.expected[0] || reloaded.LogLevel != test.expected[1] {
+			t.Errorf("%v: expected %v, got %d and %d", test.args, test.expected, options.LogLevel, reloaded.LogLevel)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+// DefaultMessageLevel is the lowest severity of messages forwarded from a
+// backend to the editor, unless configured otherwise.
+const DefaultMessageLevel = LogLevelInfo
+
+func messageLevel(messageType protocol.MessageType) int {
+	switch messageType {
+	case protocol.MessageTypeError:
+		return LogLevelError
+	case protocol.MessageTypeWarning:
+		return LogLevelWarn
+	case protocol.MessageTypeInfo:
+		return LogLevelInfo
+	}
+
+	return LogLevelDebug
+}
+
+func (s *Server) forwardsMessage(id string, messageType protocol.MessageType) bool {
+	level := DefaultMessageLevel
+
+	s.mu.RLock()
+	if configured, ok := s.options.MessageLevels[id]; ok {
+		level = configured
+	}
+	s.mu.RUnlock()
+
+	return messageLevel(messageType) <= level
+}
+
+// handleLSMessageNotification logs window/logMessage and forwards messages,
+// traces and telemetry of a backend to the editor. Messages and traces are
+// prefixed with the name of the backend.
+func (s *Server) handleLSMessageNotification(method string, request map[string]interface{}, id string) error {
+	marshalledParams, _ := json.Marshal(request["params"])
+
+	switch method {
+	case "window/logMessage", "window/showMessage":
+		var params protocol.LogMessageParams
+		if err := json.Unmarshal(marshalledParams, &params); err != nil {
+			return fmt.Errorf("invalid %s params: %w", method, err)
+		}
+
+		if method == "window/logMessage" {
+			s.logMessage(id, params)
+		}
+
+		if !s.forwardsMessage(id, params.Type) {
+			return nil
+		}
+
+		params.Message = fmt.Sprintf("[%s] %s", s.displayName(id), params.Message)
+
+		return s.sendToEditor(makeNotification(method, params))
+	case "$/logTrace":
+		var params protocol.LogTraceParams
+		if err := json.Unmarshal(marshalledParams, &params); err != nil {
+			return fmt.Errorf("invalid %s params: %w", method, err)
+		}
+
+		s.mu.RLock()
+		trace := s.trace
+		s.mu.RUnlock()
+
+		if trace == protocol.TraceValueOff {
+			return nil
+		}
+
+		params.Message = fmt.Sprintf("[%s] %s", s.displayName(id), params.Message)
+
+		return s.sendToEditor(makeNotification(method, params))
+	case "telemetry/event":
+		// The payload is opaque, so it is forwarded as it is
+		return s.sendToEditor(makeNotification(method, request["params"]))
+	}
+
+	return nil
+}
+
+func (s *Server) logMessage(id string, params protocol.LogMessageParams) {
+	logger := s.backendLogger(id)
+
+	switch params.Type {
+	case protocol.MessageTypeError:
+		logger.Errorf("(%v) %s", id, params.Message)
+	case protocol.MessageTypeWarning:
+		logger.Warnf("(%v) %s", id, params.Message)
+	case protocol.MessageTypeInfo:
+		logger.Infof("(%v) %s", id, params.Message)
+	default:
+		logger.Debugf("(%v) %s", id, params.Message)
+	}
+}
+
+// setTrace forwards $/setTrace of the editor to all backends.
+func (s *Server) setTrace(request map[string]interface{}) error {
+	var params protocol.SetTraceParams
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &params); err != nil {
+		return fmt.Errorf("invalid $/setTrace params: %w", err)
+	}
+
+	s.mu.Lock()
+	s.trace = params.Value
+	s.mu.Unlock()
+
+	s.broadcast(request)
+
+	return nil
+}
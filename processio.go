@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io"
-	"os"
 	"os/exec"
+	"syscall"
 )
 
 type ProcessIO struct {
@@ -12,11 +14,27 @@ type ProcessIO struct {
 	stdout io.ReadCloser
 }
 
-func CreateProcessFromCommand(command string) *ProcessIO {
+// CreateProcessFromCommand starts command, passing each line it writes to
+// stderr to the stderr callback.
+func CreateProcessFromCommand(command string, stderr func(line string)) (*ProcessIO, error) {
 	cmd := exec.Command("bash", "-c", command)
-	cmd.Stderr = os.Stderr
-	stdin, _ := cmd.StdinPipe()
-	stdout, _ := cmd.StdoutPipe()
+	cmd.Stderr = &lineWriter{line: stderr}
+	// A process group, so that Kill also reaches the children of bash
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("CreateProcessFromCommand(): %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("CreateProcessFromCommand(): %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("CreateProcessFromCommand(): unable to start %s: %w", command, err)
+	}
 
 	processIO := &ProcessIO{
 		cmd:    cmd,
@@ -24,13 +42,26 @@ func CreateProcessFromCommand(command string) *ProcessIO {
 		stdout: stdout,
 	}
 
+	// The exit is noticed by the reader of stdout
 	go func(p *ProcessIO) {
-		defer p.Close()
-		checkerror(cmd.Start())
-		checkerror(cmd.Wait())
+		_ = cmd.Wait()
+		_ = p.Close()
 	}(processIO)
 
-	return processIO
+	return processIO, nil
+}
+
+func (p *ProcessIO) PID() int {
+	return p.cmd.Process.Pid
+}
+
+// Kill kills the process and everything it started.
+func (p *ProcessIO) Kill() error {
+	if err := syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return fmt.Errorf("Kill(): %w", err)
+	}
+
+	return nil
 }
 
 func (p *ProcessIO) Read(data []byte) (int, error) {
@@ -54,3 +85,31 @@ func (p *ProcessIO) Close() error {
 
 	return nil
 }
+
+// lineWriter splits what is written to it into lines. A trailing line without
+// newline is kept until the next write.
+type lineWriter struct {
+	buffer []byte
+	line   func(line string)
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.buffer = append(w.buffer, data...)
+
+	for {
+		end := bytes.IndexByte(w.buffer, '\n')
+		if end == -1 {
+			break
+		}
+
+		w.line(string(bytes.TrimRight(w.buffer[:end], "\r")))
+		w.buffer = w.buffer[end+1:]
+	}
+
+	if len(w.buffer) > ReadBufferSize {
+		w.line(string(w.buffer))
+		w.buffer = nil
+	}
+
+	return len(data), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import "fmt"
+
+type progress struct {
+	created    bool
+	ended      bool
+	endMessage string
+}
+
+// requestEditor sends a request from the proxy itself to the editor. IDs are
+// strings, so they can't collide with the numeric IDs of forwarded requests.
+func (s *Server) requestEditor(method string, params interface{}, callback func(result interface{}, err interface{})) error {
+	s.mu.Lock()
+	s.editorRequestSeq++
+	seq := fmt.Sprintf("proxy-ls-%d", s.editorRequestSeq)
+	s.editorRequests[seq] = callback
+	s.mu.Unlock()
+
+	return s.sendToEditor(makeRequest(seq, method, params))
+}
+
+func (s *Server) handleEditorResponse(response map[string]interface{}) {
+	seq, _ := response["id"].(string)
+
+	s.mu.Lock()
+	callback, ok := s.editorRequests[seq]
+	delete(s.editorRequests, seq)
+	s.mu.Unlock()
+
+	if responseError, hasError := response["error"]; hasError {
+		s.logger.Warnf("Received error from editor: %v", responseError)
+	}
+
+	if !ok {
+		s.logger.Warnf("Received response to unknown request %v", response["id"])
+
+		return
+	}
+
+	callback(response["result"], response["error"])
+}
+
+// beginProgress reports the start of a long running operation, if the editor
+// supports server initiated progress.
+func (s *Server) beginProgress(token string, title string) {
+	s.mu.Lock()
+	if !s.progressSupported {
+		s.mu.Unlock()
+
+		return
+	}
+
+	s.progress[token] = &progress{}
+	s.mu.Unlock()
+
+	err := s.requestEditor("window/workDoneProgress/create", map[string]interface{}{"token": token},
+		func(_ interface{}, responseError interface{}) {
+			s.mu.Lock()
+			state, ok := s.progress[token]
+
+			if !ok || responseError != nil {
+				delete(s.progress, token)
+				s.mu.Unlock()
+
+				return
+			}
+
+			state.created = true
+			ended := state.ended
+			s.mu.Unlock()
+
+			s.sendProgress(token, map[string]interface{}{
+				"kind":  "begin",
+				"title": title,
+			})
+
+			if ended {
+				s.endProgress(token, state.endMessage)
+			}
+		})
+	if err != nil {
+		s.logger.Warnf("Unable to create progress %s: %s", token, err)
+	}
+}
+
+func (s *Server) endProgress(token string, message string) {
+	s.mu.Lock()
+	state, ok := s.progress[token]
+
+	if !ok {
+		s.mu.Unlock()
+
+		return
+	}
+
+	if !state.created {
+		// Sent once the editor acknowledged the token
+		state.ended = true
+		state.endMessage = message
+		s.mu.Unlock()
+
+		return
+	}
+
+	delete(s.progress, token)
+	s.mu.Unlock()
+
+	s.sendProgress(token, map[string]interface{}{
+		"kind":    "end",
+		"message": message,
+	})
+}
+
+func (s *Server) sendProgress(token string, value map[string]interface{}) {
+	err := s.sendToEditor(makeNotification("$/progress", map[string]interface{}{
+		"token": token,
+		"value": value,
+	}))
+	if err != nil {
+		s.logger.Warnf("Unable to report progress %s: %s", token, err)
+	}
+}
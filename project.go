@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Read from the root of the workspace, the first one found is used.
+var ProjectConfigNames = []string{".proxy-ls.toml", ".proxy-ls.json"}
+
+// LoadProjectConfig reads the configuration of the project in directory, if
+// it has one. Only backends, settings and schemas can be configured per
+// project, so that opening a project can't run the commands it names.
+func LoadProjectConfig(directory string) (Config, string, error) {
+	for _, name := range ProjectConfigNames {
+		path := filepath.Join(directory, name)
+
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return Config{}, path, fmt.Errorf("LoadProjectConfig(): %w", err)
+		}
+
+		var config Config
+		if strings.HasSuffix(name, ".toml") {
+			err = toml.Unmarshal(data, &config)
+		} else {
+			err = json.Unmarshal(data, &config)
+		}
+
+		if err != nil {
+			return Config{}, path, fmt.Errorf("LoadProjectConfig(): %s: %w", path, err)
+		}
+
+		restricted, err := restrictedConfig(config)
+		if err != nil {
+			return Config{}, path, fmt.Errorf("LoadProjectConfig(): %s: %w", path, err)
+		}
+
+		return restricted, path, nil
+	}
+
+	return Config{}, "", nil
+}
+
+// restrictedConfig returns the parts of a configuration that can't run
+// commands, after checking the backends it names.
+func restrictedConfig(config Config) (Config, error) {
+	ids := append(append([]string{}, config.Disabled...), config.Enabled...)
+	for id := range config.Formatters {
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		if _, err := str2int(id); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return Config{
+		Disabled:   config.Disabled,
+		Enabled:    config.Enabled,
+		Settings:   config.Settings,
+		Schemas:    config.Schemas,
+		Scopes:     config.Scopes,
+		Formatters: config.Formatters,
+	}, nil
+}
+
+func uriPath(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return ""
+	}
+
+	return parsed.Path
+}
+
+func pathURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// loadProject reads the project configuration of the first workspace folder.
+// An invalid configuration is logged and ignored.
+func (s *Server) loadProject() {
+	s.mu.RLock()
+	folders := s.currentWorkspaceFoldersLocked()
+	s.mu.RUnlock()
+
+	var (
+		config Config
+		root   string
+		path   string
+		err    error
+	)
+
+	if len(folders) > 0 {
+		root = uriPath(folders[0].URI)
+	}
+
+	if root != "" {
+		config, path, err = LoadProjectConfig(root)
+	}
+
+	if err != nil {
+		s.logger.Errorf("Ignoring the project configuration: %s", err)
+
+		config = Config{}
+	} else if path != "" {
+		s.logger.Infof("Using the project configuration %s", path)
+	}
+
+	s.mu.Lock()
+	s.project = config
+	s.projectRoot = root
+	s.mu.Unlock()
+}
+
+// isDisabledLocked tells whether the editor, project or user configuration
+// disables a backend.
+func (s *Server) isDisabledLocked(id string) bool {
+	for _, layer := range []Config{s.editorConfig, s.project} {
+		for _, enabled := range layer.Enabled {
+			if enabled == id {
+				return false
+			}
+		}
+
+		for _, disabled := range layer.Disabled {
+			if disabled == id {
+				return true
+			}
+		}
+	}
+
+	return s.options.Disabled[id]
+}
+
+// formatsLocked tells whether a backend formats its documents.
+func (s *Server) formatsLocked(id string) bool {
+	for _, formatters := range []map[string]bool{s.editorConfig.Formatters, s.project.Formatters, s.options.Formatters} {
+		if formats, ok := formatters[id]; ok {
+			return formats
+		}
+	}
+
+	return true
+}
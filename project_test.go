@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadProjectConfig(t *testing.T) {
+	directory := t.TempDir()
+
+	config, path, err := LoadProjectConfig(directory)
+	if err != nil || path != "" || config.Settings != nil {
+		t.Fatalf("expected no configuration, got %v at %q (%v)", config, path, err)
+	}
+
+	toml := `disabled = ["rome"]
+
+[backends.json]
+command = "evil"
+
+[settings.python.analysis]
+typeCheckingMode = "basic"
+
+[schemas]
+"https://example.com/schema.json" = ["*.example.yaml"]
+`
+	if err := os.WriteFile(filepath.Join(directory, ".proxy-ls.toml"), []byte(toml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(directory, ".proxy-ls.json"), []byte(`{"disabled": ["xml"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, path, err = LoadProjectConfig(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Base(path) != ".proxy-ls.toml" {
+		t.Errorf("expected the TOML configuration to take precedence, got %s", path)
+	}
+
+	if !reflect.DeepEqual(config.Disabled, []string{"rome"}) || config.Backends != nil {
+		t.Errorf("expected only backends to be disabled, got %v", config)
+	}
+
+	if value, _ := lookupSettings(config.Settings, "python.analysis.typeCheckingMode"); value != "basic" {
+		t.Errorf("expected the python settings, got %v", config.Settings)
+	}
+
+	if patterns := config.Schemas["https://example.com/schema.json"]; len(patterns) != 1 {
+		t.Errorf("expected the schema associations, got %v", config.Schemas)
+	}
+
+	if err := os.WriteFile(filepath.Join(directory, ".proxy-ls.toml"), []byte(`disabled = ["cobol"]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := LoadProjectConfig(directory); err == nil {
+		t.Errorf("expected an error for an unknown backend")
+	}
+}
+
+func TestMergeSettings(t *testing.T) {
+	defaults := map[string]interface{}{
+		"analysis": map[string]interface{}{"typeCheckingMode": "strict", "logLevel": "Trace"},
+		"paths":    []interface{}{"a"},
+	}
+	merged := mergeSettings(defaults, map[string]interface{}{
+		"analysis": map[string]interface{}{"typeCheckingMode": "basic"},
+		"paths":    []interface{}{"b"},
+	})
+
+	expected := map[string]interface{}{
+		"analysis": map[string]interface{}{"typeCheckingMode": "basic", "logLevel": "Trace"},
+		"paths":    []interface{}{"b"},
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+
+	if analysis, _ := defaults["analysis"].(map[string]interface{}); analysis["typeCheckingMode"] != "strict" {
+		t.Errorf("defaults were modified: %v", defaults)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/withmandala/go-log"
+)
+
+// The editor can configure proxy-ls with a proxyLs object in the
+// initializationOptions and in the settings of workspace/didChangeConfiguration.
+// It accepts what a project configuration accepts and the log level.
+const ProxySettingsKey = "proxyLs"
+
+// parseProxySettings reads the proxyLs object of the editor.
+func parseProxySettings(value interface{}) (Config, error) {
+	var config Config
+
+	data, _ := json.Marshal(value)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("parseProxySettings(): %w", err)
+	}
+
+	restricted, err := restrictedConfig(config)
+	if err != nil {
+		return Config{}, fmt.Errorf("parseProxySettings(): %w", err)
+	}
+
+	if config.LogLevel != "" {
+		if _, err := parseLogLevel(config.LogLevel); err != nil {
+			return Config{}, fmt.Errorf("parseProxySettings(): %w", err)
+		}
+	}
+
+	restricted.LogLevel = config.LogLevel
+
+	return restricted, nil
+}
+
+// setProxySettings replaces the proxyLs object of the editor. An invalid
+// object is logged and the previous one kept. It returns whether the backends
+// have to be synced with the configuration.
+func (s *Server) setProxySettings(value interface{}) bool {
+	config := Config{}
+
+	if value != nil {
+		var err error
+		if config, err = parseProxySettings(value); err != nil {
+			s.logger.Errorf("Ignoring the %s settings of the editor: %s", ProxySettingsKey, err)
+
+			return false
+		}
+	}
+
+	s.mu.Lock()
+	previous := s.editorConfig
+	s.editorConfig = config
+	level := s.options.LogLevel
+	writer := s.options.LogLevelWriter
+
+	if config.LogLevel != previous.LogLevel {
+		// Backends without a level of their own follow the proxy
+		s.backendLoggers = make(map[string]*log.Logger, LanguageServerCount)
+	}
+	s.mu.Unlock()
+
+	if config.LogLevel != previous.LogLevel {
+		if config.LogLevel != "" {
+			level, _ = parseLogLevel(config.LogLevel)
+		}
+
+		if writer != nil {
+			writer.setLevel(s.logger, level)
+		}
+
+		s.logger.Infof("Log level set to %s by the editor", logLevelName(level))
+	}
+
+	return !reflect.DeepEqual(config.Enabled, previous.Enabled) || !reflect.DeepEqual(config.Disabled, previous.Disabled)
+}
+
+// initializationProxySettings returns the proxyLs object of the
+// initializationOptions, if there is one.
+func initializationProxySettings(options interface{}) interface{} {
+	if options, ok := options.(map[string]interface{}); ok {
+		return options[ProxySettingsKey]
+	}
+
+	return nil
+}
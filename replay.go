@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Replay feeds the messages an editor sent in a trace into a new server, keeping
+// the delays between them. Everything the server sends to the editor is written
+// to out as JSONL. Requests of the server are answered with the recorded
+// responses, if there are any.
+func Replay(entries []TraceEntry, options Options, out io.Writer) error {
+	serverIn, editorOut := io.Pipe()
+	editorIn, serverOut := io.Pipe()
+	server := NewServer(newJSONRPC(serverIn, serverOut), options)
+	editor := newJSONRPC(editorIn, editorOut)
+
+	recorded := make(map[string]json.RawMessage, PendingRequestsSize)
+	messages := make([]TraceEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.Connection != EditorConnection || entry.Direction != TraceReceived {
+			continue
+		}
+
+		decoded, err := decodeMessages(entry.Message)
+		if err != nil {
+			return fmt.Errorf("Replay(): %w", err)
+		}
+
+		// A batch can mix requests and notifications with responses
+		sent := make([]map[string]interface{}, 0, len(decoded))
+
+		for _, message := range decoded {
+			if _, ok := message["method"]; ok {
+				sent = append(sent, message)
+
+				continue
+			}
+
+			response, _ := json.Marshal(message)
+			recorded[fmt.Sprint(message["id"])] = response
+		}
+
+		switch {
+		case len(sent) == 0:
+			continue
+		case len(sent) < len(decoded):
+			entry.Message, _ = json.Marshal(sent)
+		}
+
+		messages = append(messages, entry)
+	}
+
+	var mu sync.Mutex
+
+	outstanding := make(map[string]bool, PendingRequestsSize)
+	answered := make(chan struct{}, 1)
+
+	go server.Serve()
+	go func() {
+		for {
+			data, err := editor.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			_, _ = out.Write(append(data, '\n'))
+
+			decoded, err := decodeMessages(data)
+			if err != nil {
+				continue
+			}
+
+			for _, message := range decoded {
+				id, hasID := message["id"]
+				if !hasID {
+					continue
+				}
+
+				if _, ok := message["method"]; !ok {
+					mu.Lock()
+					delete(outstanding, fmt.Sprint(id))
+					mu.Unlock()
+
+					select {
+					case answered <- struct{}{}:
+					default:
+					}
+
+					continue
+				}
+
+				response, ok := recorded[fmt.Sprint(id)]
+				if !ok {
+					response, _ = json.Marshal(makeResponse(id, nil))
+				}
+
+				_ = editor.SendMessage(response)
+			}
+		}
+	}()
+
+	for i, entry := range messages {
+		if i > 0 {
+			time.Sleep(entry.Timestamp.Sub(messages[i-1].Timestamp))
+		}
+
+		decoded, _ := decodeMessages(entry.Message)
+
+		mu.Lock()
+		for _, message := range decoded {
+			if id, ok := message["id"]; ok {
+				outstanding[fmt.Sprint(id)] = true
+			}
+		}
+		mu.Unlock()
+
+		if err := editor.SendMessage(entry.Message); err != nil {
+			return fmt.Errorf("Replay(): %w", err)
+		}
+	}
+
+	timeout := time.After(InitializeTimeout)
+
+	for {
+		mu.Lock()
+		remaining := len(outstanding)
+		mu.Unlock()
+
+		if remaining == 0 {
+			break
+		}
+
+		select {
+		case <-answered:
+		case <-timeout:
+			return fmt.Errorf("Replay(): %d requests were not answered within %s", remaining, InitializeTimeout)
+		}
+	}
+
+	_ = editorOut.Close()
+
+	return nil
+}
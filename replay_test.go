@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A replayOutput collects what Replay writes, which happens from another goroutine.
+type replayOutput struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (o *replayOutput) Write(data []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.buffer.Write(data)
+}
+
+// responses returns the responses that were written, keyed by ID.
+func (o *replayOutput) responses(t *testing.T) map[string]map[string]interface{} {
+	t.Helper()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	responses := make(map[string]map[string]interface{})
+
+	for _, line := range bytes.Split(o.buffer.Bytes(), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		messages, err := decodeMessages(line)
+		if err != nil {
+			t.Fatalf("replay wrote invalid message %q: %s", line, err)
+		}
+
+		for _, message := range messages {
+			if _, ok := message["method"]; !ok {
+				responses[fmt.Sprint(message["id"])] = message
+			}
+		}
+	}
+
+	return responses
+}
+
+func editorEntry(timestamp time.Time, message string) TraceEntry {
+	return TraceEntry{
+		Timestamp:  timestamp,
+		Direction:  TraceReceived,
+		Connection: EditorConnection,
+		Message:    json.RawMessage(message),
+	}
+}
+
+func TestReplaySendsEveryMessageOfABatch(t *testing.T) {
+	now := time.Now()
+	entries := []TraceEntry{
+		editorEntry(now, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"rootUri":"file:///project","capabilities":{}}}`),
+		// Sent by proxy-ls, not replayed
+		{Timestamp: now, Direction: TraceSent, Connection: EditorConnection, Message: json.RawMessage(`{"jsonrpc":"2.0","id":3,"method":"proxy/status"}`)},
+		// A response first, then a notification and a request
+		editorEntry(now.Add(time.Millisecond), `[{"jsonrpc":"2.0","id":"x","result":null},{"jsonrpc":"2.0","method":"initialized","params":{}},{"jsonrpc":"2.0","id":2,"method":"proxy/status"}]`),
+	}
+
+	var out replayOutput
+	if err := Replay(entries, Options{Backends: func(id string, _ string) (*JSONRPC, error) {
+		return nil, fmt.Errorf("%s is not installed", id)
+	}}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	responses := out.responses(t)
+	if responses["1"]["result"] == nil || responses["2"]["result"] == nil {
+		t.Errorf("expected initialize and proxy/status to be answered, got %v", responses)
+	}
+
+	if _, ok := responses["3"]; ok {
+		t.Errorf("expected messages sent by proxy-ls not to be replayed, got %v", responses["3"])
+	}
+}
+
+func TestRecordedSessionIsReplayed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+
+	tracer, err := NewTracer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1, "hoverProvider": true})
+	recorded.Responses["textDocument/hover"] = map[string]interface{}{"contents": "recorded"}
+
+	editor := newTestServerWithOptions(t, Options{
+		Tracer: tracer,
+		Backends: func(id string, _ string) (*JSONRPC, error) {
+			if id != "json" {
+				return nil, fmt.Errorf("%s is not installed", id)
+			}
+
+			return recorded.connect(), nil
+		},
+	})
+	editor.Initialize()
+	editor.Notify("initialized", map[string]interface{}{})
+	editor.Open("file:///project/a.json", "json", "{}")
+
+	hover := map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.json"},
+		"position":     map[string]interface{}{"line": 0, "character": 1},
+	}
+	if response := editor.Request("textDocument/hover", hover); response["result"] == nil {
+		t.Fatalf("expected a hover, got %v", response)
+	}
+
+	_ = tracer.Close()
+
+	entries, err := ReadTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := NewFakeBackend(t, recorded.Capabilities)
+	replayed.Responses["textDocument/hover"] = map[string]interface{}{"contents": "replayed"}
+
+	var out replayOutput
+	if err := Replay(entries, Options{Backends: func(id string, _ string) (*JSONRPC, error) {
+		if id != "json" {
+			return nil, fmt.Errorf("%s is not installed", id)
+		}
+
+		return replayed.connect(), nil
+	}}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	opened := filterMethod(replayed.WaitFor("textDocument/didOpen"), "textDocument/didOpen")
+	if len(opened) != 1 {
+		t.Errorf("expected the document to be opened again, got %v", opened)
+	}
+
+	hovers := filterMethod(replayed.WaitFor("textDocument/hover"), "textDocument/hover")
+	if position := fmt.Sprint(hovers[0]["params"].(map[string]interface{})["position"]); position != fmt.Sprint(hover["position"]) {
+		t.Errorf("expected the recorded position, got %s", position)
+	}
+
+	response := out.responses(t)["2"]
+	if result, _ := response["result"].(map[string]interface{}); result["contents"] != "replayed" {
+		t.Errorf("expected the hover of the replayed backend, got %v", response)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "encoding/json"
+
+// Resolve requests carry no document, so the items they resolve are tagged
+// with the backend that produced them. The tag wraps the original data, which
+// is restored before the item goes back to the backend.
+const (
+	ResolveBackendKey = "proxy-ls.backend"
+	ResolveDataKey    = "proxy-ls.data"
+)
+
+func tagResolveData(id string, value interface{}) {
+	item, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if _, isCommand := item["command"].(string); isCommand {
+		return // A Command can't be resolved
+	}
+
+	tag := map[string]interface{}{ResolveBackendKey: id}
+	if data, ok := item["data"]; ok {
+		tag[ResolveDataKey] = data
+	}
+
+	item["data"] = tag
+}
+
+// tagResolvable tags the resolvable items in a result of method.
+func tagResolvable(id string, method string, result interface{}) interface{} {
+	switch method {
+	case "textDocument/completion":
+		items, ok := result.([]interface{})
+		if list, isList := result.(map[string]interface{}); isList {
+			items, ok = list["items"].([]interface{})
+		}
+
+		if !ok {
+			break
+		}
+
+		for _, item := range items {
+			tagResolveData(id, item)
+		}
+	case "textDocument/codeAction", "textDocument/codeLens", "textDocument/documentLink", "textDocument/inlayHint",
+		"workspace/symbol":
+		if items, ok := result.([]interface{}); ok {
+			for _, item := range items {
+				tagResolveData(id, item)
+			}
+		}
+	case "completionItem/resolve", "codeAction/resolve", "codeLens/resolve", "documentLink/resolve", "inlayHint/resolve",
+		"workspaceSymbol/resolve":
+		tagResolveData(id, result)
+	}
+
+	return result
+}
+
+// redirectResolve sends a resolve request to the backend named in the tag of
+// the item, with the original data of the item.
+func (s *Server) redirectResolve(request map[string]interface{}) error {
+	var item map[string]interface{}
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &item); err != nil {
+		return newResponseError(InvalidParams, "Invalid %v params: %s", request["method"], err)
+	}
+
+	tag, _ := item["data"].(map[string]interface{})
+
+	id, ok := tag[ResolveBackendKey].(string)
+	if !ok {
+		return newResponseError(InvalidParams, "%v of an item proxy-ls didn't return", request["method"])
+	}
+
+	if data, ok := tag[ResolveDataKey]; ok {
+		item["data"] = data
+	} else {
+		delete(item, "data")
+	}
+
+	method, _ := request["method"].(string)
+
+	return s.redirectRequest(id, makeRequest(request["id"], method, item), nil)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Requests whose document isn't named by params.textDocument.uri, mapped to
+// the function finding it.
+var requestDocuments = map[string]func(params map[string]interface{}) string{
+	"callHierarchy/incomingCalls": itemURI,
+	"callHierarchy/outgoingCalls": itemURI,
+}
+
+// Requests resolving an item returned earlier, routed by the tag of the item.
+var resolveRequests = map[string]bool{
+	"completionItem/resolve":  true,
+	"codeAction/resolve":      true,
+	"codeLens/resolve":        true,
+	"documentLink/resolve":    true,
+	"inlayHint/resolve":       true,
+	"workspaceSymbol/resolve": true,
+}
+
+func itemURI(params map[string]interface{}) string {
+	if item, ok := params["item"].(map[string]interface{}); ok {
+		if uri, ok := item["uri"].(string); ok {
+			return uri
+		}
+	}
+
+	return ""
+}
+
+// routeRequest forwards any request about a document to the backend owning
+// it, and resolve requests to the backend that returned the item.
+func (s *Server) routeRequest(method string, request map[string]interface{}) error {
+	if resolveRequests[method] {
+		return s.redirectResolve(request)
+	}
+
+	var params map[string]interface{}
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &params); err != nil {
+		return newResponseError(InvalidParams, "Invalid %s params: %s", method, err)
+	}
+
+	var uri string
+
+	if document, ok := requestDocuments[method]; ok {
+		uri = document(params)
+	} else if strings.HasPrefix(method, "textDocument/") {
+		uri = documentURI(params)
+	}
+
+	if uri == "" {
+		return newResponseError(MethodNotFound, "Method not found")
+	}
+
+	if formattingRequests[method] {
+		owner, err := s.selectLSForFile(uri, "", true)
+
+		s.mu.RLock()
+		formats := err != nil || s.formatsLocked(owner)
+		s.mu.RUnlock()
+
+		if !formats {
+			// Formatting is turned off, the document is left as it is
+			return s.sendToEditor(makeResponse(request["id"], nil))
+		}
+
+		s.applyFormattingOptions(uri, params)
+		request["params"] = params
+	}
+
+	return s.redirectToOwner(uri, request)
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"runtime/debug"
 	"strings"
 	"sync"
-	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-set"
 	protocol "github.com/tliron/glsp/protocol_3_16"
@@ -16,430 +18,762 @@ type Server struct {
 	logger               *log.Logger
 	jsonrpc              *JSONRPC
 	mu                   sync.RWMutex
-	jsonLS               *ProcessIO
-	xmlLS                *ProcessIO
-	yamlLS               *ProcessIO
-	jsonrpcs             map[string]*JSONRPC
-	initialized          map[string]bool
+	diagnosticsMu        sync.Mutex
 	diagnostics          map[protocol.URI]([]protocol.Diagnostic)
-	pendingRequests      *set.Set[int]
+	pendingRequests      map[int]*pendingRequest
+	documents            *DocumentStore
+	embedded             map[string][]*EmbeddedDocument
+	virtualDocuments     map[string]*EmbeddedDocument
 	flatpakManifests     *set.Set[string]
 	yamlFlatpakManifests *set.Set[string]
 	gschemaFiles         *set.Set[string]
 	gresourceFiles       *set.Set[string]
+	positionEncoding     string
+	editorInitialized    bool
+	editorRequests       map[string]func(result interface{}, err interface{})
+	editorRequestSeq     int
+	progressSupported    bool
+	progress             map[string]*progress
+	options              Options
+	backends             map[string]*Backend
+	backendLoggers       map[string]*log.Logger
+	logOutputs           map[string]log.FdWriter
+	initialization       *initialization
+	// The backends owning each command, proxy-ls owns those with an empty ID
+	commandOwners   map[string]string
+	dynamicCommands bool
+	// The requests of backendDocumentRequests the editor can register
+	dynamicDocumentRequests map[string]bool
+	// nil until the editor opens a folder
+	workspaceFolders []protocol.WorkspaceFolder
+	// The configuration of the project in the first workspace folder
+	project     Config
+	projectRoot string
+	// Pushed by the editor with workspace/didChangeConfiguration
+	editorSettings map[string]interface{}
+	// The proxyLs settings of the editor
+	editorConfig Config
+	// The FormattingOptions of the last formatting request for each document
+	formatting map[string]map[string]interface{}
+	// Whether the editor reports changed files, otherwise they are watched
+	// with inotify
+	editorWatchesFiles bool
+	watcher            *inotifyWatcher
+	trace              protocol.TraceValue
 }
 
-func NewServer(jsonrpc *JSONRPC) *Server {
+type Options struct {
+	Tracer *Tracer
+	// Commands replaces the commands backends are started with, keyed by backend
+	Commands map[string]string
+	// Backends starts the backends, by default as processes
+	Backends BackendFactory
+	Disabled map[string]bool
+	Logger   *log.Logger
+	// Backends log to LogOutput, by default stderr, and to a file in LogDirectory
+	LogOutput        log.FdWriter
+	LogDirectory     string
+	BackendLogLevels map[string]int
+	// The lowest severity of messages forwarded to the editor, keyed by backend
+	MessageLevels map[string]int
+	// Reload reads the configuration again, for proxy.reloadConfig
+	Reload func() (Options, error)
+	// Merged into the settings backends get, keyed by section
+	Settings map[string]interface{}
+	// Glob patterns of the JSON and YAML files each schema applies to
+	Schemas map[string][]string
+	// Settings for the documents in a directory, keyed by absolute path
+	Scopes map[string]map[string]interface{}
+	// Whether each backend formats its documents, by default all do
+	Formatters map[string]bool
+	// The level of Logger, restored when the editor no longer sets one
+	LogLevel int
+	// Changes the level of Logger, which keeps its level without one
+	LogLevelWriter *levelWriter
+	// The size of the largest message accepted, DefaultMaxMessageSize if 0
+	MaxMessageSize int
+	// How long backends may take to initialize, InitializeTimeout if 0
+	InitializeTimeout time.Duration
+}
+
+type pendingRequest struct {
+	backend   string
+	method    string
+	uri       string
+	transform func(result interface{}) interface{}
+	// Set for requests sent to several backends
+	fanOut *fanOut
+}
+
+func NewServer(jsonrpc *JSONRPC, options Options) *Server {
 	server := &Server{
-		logger:               log.New(os.Stderr),
+		logger:               options.Logger,
 		jsonrpc:              jsonrpc,
 		diagnostics:          make(map[protocol.URI]([]protocol.Diagnostic)),
-		pendingRequests:      set.New[int](PendingRequestsSize),
+		pendingRequests:      make(map[int]*pendingRequest, PendingRequestsSize),
+		documents:            NewDocumentStore(),
+		embedded:             make(map[string][]*EmbeddedDocument, AverageFileCount),
+		virtualDocuments:     make(map[string]*EmbeddedDocument, AverageFileCount),
+		positionEncoding:     PositionEncodingUTF16,
 		flatpakManifests:     set.New[string](AverageFileCount),
 		yamlFlatpakManifests: set.New[string](AverageFileCount),
 		gschemaFiles:         set.New[string](AverageFileCount),
 		gresourceFiles:       set.New[string](AverageFileCount),
-		jsonLS:               CreateProcessFromCommand("vscode-json-languageserver --stdio"),
-		xmlLS:                CreateProcessFromCommand("lemminx"),
-		yamlLS:               CreateProcessFromCommand("yaml-language-server --stdio"),
 		mu:                   sync.RWMutex{},
-		jsonrpcs:             make(map[string]*JSONRPC, LanguageServerCount),
-		initialized:          make(map[string]bool, LanguageServerCount),
+		editorRequests:       make(map[string]func(result interface{}, err interface{}), PendingRequestsSize),
+		progress:             make(map[string]*progress, LanguageServerCount),
+		options:              options,
+		backends:             make(map[string]*Backend, LanguageServerCount),
+		backendLoggers:       make(map[string]*log.Logger, LanguageServerCount),
+		logOutputs:           make(map[string]log.FdWriter, LanguageServerCount),
+		trace:                protocol.TraceValueOff,
+		commandOwners:        make(map[string]string, len(proxyCommands)),
+		formatting:           make(map[string]map[string]interface{}, AverageFileCount),
+	}
+	jsonrpc.Trace(options.Tracer, EditorConnection)
+
+	if options.MaxMessageSize > 0 {
+		jsonrpc.MaxMessageSize = options.MaxMessageSize
+	}
+
+	if server.logger == nil {
+		server.logger = log.New(os.Stderr)
+	}
+
+	for _, command := range proxyCommands {
+		server.commandOwners[command] = ""
 	}
-	server.jsonrpcs["yaml"] = jsonrpcFromProcessIO(server.yamlLS)
-	server.jsonrpcs["json"] = jsonrpcFromProcessIO(server.jsonLS)
-	server.jsonrpcs["xml"] = jsonrpcFromProcessIO(server.xmlLS)
-	server.jsonrpcs["ruff"] = jsonrpcFromProcessIO(CreateProcessFromCommand("ruff-lsp"))
-	server.jsonrpcs["rome"] = jsonrpcFromProcessIO(CreateProcessFromCommand("rome lsp-proxy"))
 
-	go server.runLS(server.jsonrpcs["yaml"], "yaml")
-	go server.runLS(server.jsonrpcs["json"], "json")
-	go server.runLS(server.jsonrpcs["xml"], "xml")
-	go server.runLS(server.jsonrpcs["ruff"], "ruff")
-	go server.runLS(server.jsonrpcs["rome"], "rome")
+	for _, backend := range defaultBackends {
+		if options.Disabled[backend.ID] {
+			server.logger.Infof("(%v) Disabled", backend.ID)
+
+			continue
+		}
+
+		server.startBackend(backend.ID, 0)
+	}
 
 	return server
 }
 
-func (s *Server) runLS(jsonrpc *JSONRPC, id string) {
+func (s *Server) sendToEditor(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("unable to encode message for the editor: %w", err)
+	}
+
+	return s.jsonrpc.SendMessage(data)
+}
+
+func (s *Server) runLS(backend *Backend) {
+	id := backend.ID
+
 	for {
-		messageData, err := jsonrpc.ReadMessage()
+		messageData, err := backend.rpc.ReadMessage()
 		if err != nil {
-			s.logger.Errorf("(%v) Error reading message: %s\n", id, err)
+			s.backendExited(backend, fmt.Errorf("%s exited: %w", s.displayName(id), err))
 
 			return
 		}
 
-		var request map[string]interface{}
-		if err := json.Unmarshal(messageData, &request); err != nil {
-			s.logger.Errorf("(%v) Error decoding request: %s\n", id, err)
+		requests, err := decodeMessages(messageData)
+		if err != nil {
+			// Skipped, a request it might have been stays unanswered
+			s.logger.Errorf("(%v) Error decoding message: %s", id, err)
 
-			return
+			continue
 		}
 
-		if _, ok := request["id"]; ok {
-			s.handleLSResponse(request, jsonrpc, id)
-		} else {
-			s.handleLSNotification(request, jsonrpc, id)
+		for _, request := range requests {
+			s.handleLSMessage(request, backend)
+		}
+	}
+}
+
+// handleLSMessage handles one message from a backend. Errors and panics are
+// contained here, so a misbehaving backend can't take down the proxy.
+func (s *Server) handleLSMessage(request map[string]interface{}, backend *Backend) {
+	id := backend.ID
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Errorf("(%v) Panic while handling message: %v\n%s", id, r, debug.Stack())
 		}
+	}()
+
+	var err error
+	if _, ok := request["id"]; ok {
+		err = s.handleLSResponse(request, backend)
+	} else {
+		err = s.handleLSNotification(request, id)
+	}
+
+	if err != nil {
+		s.logger.Warnf("(%v) Unable to handle message: %s", id, err)
 	}
 }
 
-func (s *Server) handleLSResponse(request map[string]interface{}, rpc *JSONRPC, id string) {
-	s.logger.Infof("Response: (%v) %d", id, ExtractIntValue(request["id"]))
+func (s *Server) handleLSResponse(request map[string]interface{}, backend *Backend) error {
+	id := backend.ID
 
 	if _, ok := request["error"]; ok {
 		s.logger.Warnf("Received error from %v: %v", id, request["error"])
 	}
 
-	if _, ok := request["params"]; ok {
-		stringified, _ := json.Marshal(request["params"])
+	if method, ok := request["method"].(string); ok {
+		if editorMethods[method] {
+			return s.forwardToEditor(method, request, id)
+		}
 
-		method, ok := request["method"].(string)
-		checkok(ok)
+		result, err := s.handleLSRequest(method, request["params"], id)
+		if err != nil {
+			return s.sendToBackend(id, makeErrorResponse(request["id"], err))
+		}
 
-		if method == "client/registerCapability" {
-			call := makeResponse(request["id"], nil)
-			data, _ := json.Marshal(call)
-			checkerror(s.jsonrpcs[id].SendMessage(data))
+		return s.sendToBackend(id, makeResponse(request["id"], result))
+	}
 
-			return
+	seqID, err := ExtractIntValue(request["id"])
+	if err != nil {
+		return err
+	}
+
+	s.logger.Infof("Response: (%v) %d", id, seqID)
+
+	if seqID == 1 {
+		s.mu.RLock()
+		starting := backend.state == BackendStarting && !backend.stopped
+		s.mu.RUnlock()
+
+		if !starting {
+			s.logger.Warnf("(%v) Ignoring the initialize response of a backend that timed out or stopped", id)
+
+			return nil
 		}
 
-		if method != "workspace/configuration" {
-			s.logger.Warnf("Unable to handle %s with params %s", method, stringified)
+		if responseError, ok := request["error"]; ok {
+			s.markReady(backend, fmt.Errorf("%s failed to initialize: %v", s.displayName(id), responseError))
 
-			return
+			return nil
 		}
 
-		s.logger.Infof("Querying config...: %s", stringified)
+		var result struct {
+			Capabilities struct {
+				PositionEncoding       string          `json:"positionEncoding"`
+				TextDocumentSync       json.RawMessage `json:"textDocumentSync"`
+				ExecuteCommandProvider struct {
+					Commands []string `json:"commands"`
+				} `json:"executeCommandProvider"`
+				// Either a boolean or options
+				WorkspaceSymbolProvider interface{} `json:"workspaceSymbolProvider"`
+			} `json:"capabilities"`
+		}
 
-		requested, ok := request["params"].(map[string]interface{})["items"].([]interface{})
-		checkok(ok)
+		marshalledResult, _ := json.Marshal(request["result"])
+		if err := json.Unmarshal(marshalledResult, &result); err != nil || result.Capabilities.PositionEncoding == "" {
+			result.Capabilities.PositionEncoding = PositionEncodingUTF16
+		}
 
-		var returned []interface{}
+		syncKind := parseSyncKind(result.Capabilities.TextDocumentSync)
+		s.logger.Infof("%s uses position encoding %s and sync kind %d", id, result.Capabilities.PositionEncoding, syncKind)
 
-		for _, item := range requested {
-			section, ok := item.(map[string]interface{})["section"].(string)
-			checkok(ok)
+		s.mu.Lock()
+		backend.encoding = result.Capabilities.PositionEncoding
+		backend.syncKind = syncKind
+		backend.workspaceSymbols = result.Capabilities.WorkspaceSymbolProvider != nil &&
+			result.Capabilities.WorkspaceSymbolProvider != false
+		s.mu.Unlock()
+		s.registerCommands(backend, result.Capabilities.ExecuteCommandProvider.Commands)
 
-			switch section {
-			case "xml.format.insertSpaces":
-				returned = append(returned, true)
-			case "xml.format.tabSize":
-				returned = append(returned, DefaultTabSize)
-			case "yaml":
-				schemas := map[string]interface{}{
-					"https://raw.githubusercontent.com/flatpak/flatpak-builder/main/data/flatpak-manifest.schema.json": s.yamlFlatpakManifests.Slice(),
-				}
-				returned = append(returned, yamlConfig(schemas))
-			case "[yaml]":
-				returned = append(returned, map[string]interface{}{
-					"editor.tabSize":      DefaultTabSize,
-					"editor.insertSpace":  true,
-					"editor.formatOnType": false,
-				})
-			case "editor":
-				returned = append(returned, map[string]interface{}{
-					"detectIndentation": true,
-				})
-			case "files":
-				returned = append(returned, map[string]interface{}{})
-			case "rome":
-				returned = append(returned, map[string]interface{}{
-					"unstable":              true,
-					"rename":                true,
-					"require_configuration": true,
-				})
-			default:
-				s.logger.Warnf("Unable to handle configuration %s from %s", section, id)
-
-				returned = append(returned, nil)
+		var capabilities struct {
+			Capabilities map[string]interface{} `json:"capabilities"`
+		}
+
+		_ = json.Unmarshal(marshalledResult, &capabilities)
+		s.registerDocumentRequests(backend, declaredDocumentRequests(capabilities.Capabilities))
+
+		if err := s.sendToBackend(id, makeNotification("initialized", map[string]interface{}{})); err != nil {
+			return err
+		}
+
+		s.markReady(backend, nil)
+
+		return nil // Initialization succeeded
+	}
+
+	s.mu.Lock()
+	pending, ok := s.pendingRequests[seqID]
+	delete(s.pendingRequests, seqID)
+	s.mu.Unlock()
+
+	if !ok {
+		return s.sendToEditor(request)
+	}
+
+	factor, err := str2int(pending.backend)
+	if err != nil {
+		return err
+	}
+
+	request["id"] = seqID - (factor * LanguageServerFactor)
+
+	if pending.fanOut != nil {
+		result := request["result"]
+		if result != nil {
+			converter := s.newPositionConverter(s.backendEncoding(pending.backend), s.editorEncoding())
+			result = tagResolvable(pending.backend, pending.method, converter.Convert(result, pending.uri))
+		}
+
+		s.collect(pending.fanOut, result)
+
+		return nil
+	}
+
+	if result, hasResult := request["result"]; hasResult && result != nil {
+		converter := s.newPositionConverter(s.backendEncoding(pending.backend), s.editorEncoding())
+		result = converter.Convert(result, pending.uri)
+
+		result = s.exposeCommands(pending.backend, result)
+		result = tagResolvable(pending.backend, pending.method, result)
+
+		if pending.transform != nil {
+			result = pending.transform(result)
+		}
+
+		request["result"] = result
+	}
+
+	return s.sendToEditor(request)
+}
+
+// handleLSRequest answers requests that backends send to the proxy.
+// Requests of backends that only the editor can answer.
+var editorMethods = map[string]bool{
+	"workspace/applyEdit":            true,
+	"window/showMessageRequest":      true,
+	"window/showDocument":            true,
+	"window/workDoneProgress/create": true,
+}
+
+// forwardToEditor sends a request of a backend to the editor and the answer
+// back to the backend, under the ID the backend chose.
+func (s *Server) forwardToEditor(method string, request map[string]interface{}, id string) error {
+	converter := s.newPositionConverter(s.backendEncoding(id), s.editorEncoding())
+	params := converter.Convert(request["params"], documentURI(request["params"]))
+	seq := request["id"]
+
+	return s.requestEditor(method, params, func(result interface{}, responseError interface{}) {
+		response := makeResponse(seq, result)
+		if responseError != nil {
+			response = map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      seq,
+				"error":   responseError,
 			}
 		}
 
-		call := makeResponse(request["id"], returned)
-		data, _ := json.Marshal(call)
-		s.logger.Infof("Returned config: %s", string(data))
-		checkerror(s.jsonrpcs[id].SendMessage(data))
+		if err := s.sendToBackend(id, response); err != nil {
+			s.logger.Warnf("(%v) Unable to answer %s: %s", id, method, err)
+		}
+	})
+}
 
-		return
+func (s *Server) handleLSRequest(method string, params interface{}, id string) (interface{}, error) {
+	stringified, _ := json.Marshal(params)
+
+	switch method {
+	case "client/registerCapability":
+		return nil, s.registerWatchers(id, params)
+	case "client/unregisterCapability":
+		return nil, s.unregisterWatchers(id, params)
+	case "workspace/workspaceFolders":
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		return s.currentWorkspaceFoldersLocked(), nil
+	case "workspace/configuration":
+	default:
+		s.logger.Warnf("Unable to handle %s with params %s", method, stringified)
+
+		return nil, newResponseError(MethodNotFound, "Method not found: %s", method)
 	}
 
-	seqID := ExtractIntValue(request["id"])
-	if seqID == 1 {
-		call := makeNotification("initialized", map[string]interface{}{})
-		data, _ := json.Marshal(call)
-		checkerror(s.jsonrpcs[id].SendMessage(data))
-		s.mu.Lock()
-		s.initialized[id] = true
-		s.mu.Unlock()
+	s.logger.Infof("Querying config...: %s", stringified)
 
-		return // Initialization succeeded
+	var configurationParams protocol.ConfigurationParams
+	if err := json.Unmarshal(stringified, &configurationParams); err != nil {
+		return nil, newResponseError(InvalidParams, "Invalid workspace/configuration params: %s", err)
 	}
 
-	if s.pendingRequests.Contains(seqID) {
-		realSeqID := seqID - (str2int(id) * LanguageServerFactor)
-		request["id"] = realSeqID
-		data, _ := json.Marshal(request)
-		checkerror(s.jsonrpc.SendMessage(data))
-		s.mu.Lock()
-		s.pendingRequests.Remove(seqID)
-		s.mu.Unlock()
+	returned := make([]interface{}, 0, len(configurationParams.Items))
 
-		return
+	for _, item := range configurationParams.Items {
+		section, scope := "", ""
+		if item.Section != nil {
+			section = *item.Section
+		}
+
+		if item.ScopeURI != nil {
+			scope = *item.ScopeURI
+		}
+
+		value := s.section(section, scope)
+		if value == nil {
+			s.logger.Warnf("Unable to handle configuration %s from %s", section, id)
+		}
+
+		returned = append(returned, value)
 	}
 
-	data, _ := json.Marshal(request)
-	checkerror(s.jsonrpc.SendMessage(data))
+	data, _ := json.Marshal(returned)
+	s.logger.Infof("Returned config: %s", string(data))
+
+	return returned, nil
 }
 
-func (s *Server) publishDiagnostics() {
+func (s *Server) publishDiagnostics() error {
+	// Serialized, so an older snapshot can't overtake a newer one
+	s.diagnosticsMu.Lock()
+	defer s.diagnosticsMu.Unlock()
+
+	s.mu.RLock()
+	all := make(map[protocol.URI]([]protocol.Diagnostic), len(s.diagnostics))
+
 	for uri, diagnostics := range s.diagnostics {
+		all[uri] = append(append([]protocol.Diagnostic{}, diagnostics...), s.embeddedDiagnosticsLocked(uri)...)
+	}
+
+	for uri := range s.embedded {
+		if _, ok := all[uri]; !ok {
+			all[uri] = s.embeddedDiagnosticsLocked(uri)
+		}
+	}
+	s.mu.RUnlock()
+
+	for uri, diagnostics := range all {
 		call := makeNotification("textDocument/publishDiagnostics", protocol.PublishDiagnosticsParams{
 			URI:         uri,
 			Diagnostics: []protocol.Diagnostic{},
 		})
-		data, _ := json.Marshal(call)
-		checkerror(s.jsonrpc.SendMessage(data))
+		if err := s.sendToEditor(call); err != nil {
+			return err
+		}
 
 		call = makeNotification("textDocument/publishDiagnostics", protocol.PublishDiagnosticsParams{
 			URI:         uri,
 			Diagnostics: diagnostics,
 		})
-		data, _ = json.Marshal(call)
-		checkerror(s.jsonrpc.SendMessage(data))
+		if err := s.sendToEditor(call); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func (s *Server) handleLSNotification(request map[string]interface{}, _ *JSONRPC, id string) {
+func (s *Server) handleLSNotification(request map[string]interface{}, id string) error {
 	method, ok := request["method"].(string)
-	checkok(ok)
-
-	marshalledParams, _ := json.Marshal(request["params"])
+	if !ok {
+		return fmt.Errorf("notification without method: %v", request)
+	}
 
-	if method == "textDocument/publishDiagnostics" {
+	switch method {
+	case "textDocument/publishDiagnostics":
 		var diags protocol.PublishDiagnosticsParams
 
-		checkerror(json.Unmarshal(marshalledParams, &diags))
-		s.diagnostics[diags.URI] = diags.Diagnostics
-		s.publishDiagnostics()
-	}
+		converter := s.newPositionConverter(s.backendEncoding(id), s.editorEncoding())
+		marshalledParams, _ := json.Marshal(converter.Convert(request["params"], ""))
+
+		if err := json.Unmarshal(marshalledParams, &diags); err != nil {
+			return fmt.Errorf("invalid diagnostics: %w", err)
+		}
+
+		s.mu.Lock()
+		if document, ok := s.virtualDocuments[diags.URI]; ok {
+			document.Diagnostics = diags.Diagnostics
+		} else {
+			s.diagnostics[diags.URI] = diags.Diagnostics
+		}
+		s.mu.Unlock()
 
-	if method == "window/logMessage" {
-		params := request["params"].(map[string]interface{})
-		s.logger.Infof("%s: %s", id, params["message"])
+		return s.publishDiagnostics()
+	case "window/logMessage", "window/showMessage", "$/logTrace", "telemetry/event":
+		return s.handleLSMessageNotification(method, request, id)
 	}
+
+	return nil
 }
 
-func (s *Server) InitializeAll(rootURI *string, clientCaps protocol.ClientCapabilities) {
-	capability := true
-	clientCaps.Workspace.Configuration = &capability
-	clientCaps.TextDocument.RangeFormatting = &protocol.DocumentRangeFormattingClientCapabilities{
-		DynamicRegistration: &capability,
-	}
-
-	for _, element := range s.jsonrpcs {
-		traceValue := protocol.TraceValueVerbose
-		version := "0.0.1"
-		pid := int32(syscall.Getpid())
-		call := makeRequest(1, "initialize", protocol.InitializeParams{
-			ProcessID: &pid,
-			RootURI:   rootURI,
-			Trace:     &traceValue,
-			ClientInfo: &struct {
-				Name    string  `json:"name"`
-				Version *string `json:"version,omitempty"`
-			}{Name: "proxy-ls", Version: &version},
-			Capabilities: clientCaps,
-			InitializationOptions: map[string]interface{}{
-				"handledSchemaProtocols": []string{"file", "http", "https"},
-				"provideFormatter":       true,
-				"settings": map[string]interface{}{
-					"xml":  xmlConfig(make([](map[string]interface{}), 0)),
-					"yaml": yamlConfig(map[string]interface{}{}),
-					"pyright": map[string]interface{}{
-						"disableOrganizeImports": true, // ruff-lsp does that
-					},
-					"python": map[string]interface{}{
-						"analysis": map[string]interface{}{
-							"autoImportCompletions": true,
-							"logLevel":              "Trace",
-							"typeCheckingMode":      "strict",
-						},
-					},
-				},
-				"globalSettings": map[string]interface{}{
-					"logLevel":        "debug",
-					"run":             "onType",
-					"organizeImports": true,
-					"fixAll":          true,
-					"codeAction": map[string]interface{}{
-						"fixViolation": map[string]interface{}{
-							"enable": true,
-						},
-						"disableRuleComment": map[string]interface{}{
-							"enable": true,
-						},
-					},
-				},
-			},
-		})
-		data, _ := json.Marshal(call)
-		checkerror(element.SendMessage(data))
+func (s *Server) redirectRequest(id string, request map[string]interface{}, transform func(interface{}) interface{}) error {
+	return s.redirectPending(id, request, &pendingRequest{transform: transform})
+}
+
+// redirectPending sends a request of the editor to a backend, pending is
+// completed with the backend, method and document of the request.
+func (s *Server) redirectPending(id string, request map[string]interface{}, pending *pendingRequest) error {
+	if _, err := s.backend(id); err != nil {
+		return newResponseError(RequestFailed, "%s", err)
 	}
 
-	for {
-		s.mu.Lock()
-		if s.initialized["yaml"] && s.initialized["json"] && s.initialized["xml"] && s.initialized["ruff"] && s.initialized["rome"] {
-			s.mu.Unlock()
+	seq, err := ExtractIntValue(request["id"])
+	if err != nil {
+		return newResponseError(InvalidRequest, "%s", err)
+	}
 
-			return
-		}
-		s.mu.Unlock()
+	factor, err := str2int(id)
+	if err != nil {
+		return newResponseError(InternalError, "%s", err)
 	}
-}
 
-func (s *Server) redirectRequest(id string, request map[string]interface{}) {
-	newSeq := ExtractIntValue(request["id"]) + (LanguageServerFactor * str2int(id))
+	newSeq := seq + (LanguageServerFactor * factor)
 	s.logger.Infof("Redirecting %v to %v as new ID %v", request["method"], id, newSeq)
-	request["id"] = newSeq
-	data, _ := json.Marshal(request)
+
+	var params interface{}
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &params); err != nil {
+		return newResponseError(InvalidParams, "%s", err)
+	}
+
+	uri := documentURI(params)
+	method, _ := request["method"].(string)
+	converter := s.newPositionConverter(s.editorEncoding(), s.editorEncoding())
+	// Positions refer to the document as it is now, not once the request is sent
+	_, _ = converter.text(uri)
+	encode := func(encoding string) []byte {
+		converter.to = encoding
+		data, _ := json.Marshal(makeRequest(newSeq, method, converter.Convert(params, uri)))
+
+		return data
+	}
 
 	s.mu.Lock()
-	s.pendingRequests.Insert(newSeq)
+	pending.backend = id
+	pending.method = method
+	pending.uri = uri
+	s.pendingRequests[newSeq] = pending
 	s.mu.Unlock()
-	checkerror(s.jsonrpcs[id].SendMessage(data))
+
+	if err := s.forwardEncoded(id, encode); err != nil {
+		s.mu.Lock()
+		delete(s.pendingRequests, newSeq)
+		s.mu.Unlock()
+
+		return newResponseError(RequestFailed, "Unable to reach %s: %s", id, err)
+	}
+
+	return nil
 }
 
-func (s *Server) handleCall(request map[string]interface{}) {
+func (s *Server) handleCall(request map[string]interface{}) error {
 	serviceMethod, ok := request["method"].(string)
-	checkok(ok)
+	if !ok {
+		return newResponseError(InvalidRequest, "Request without method")
+	}
 
 	seq := request["id"]
 	marshalledParams, _ := json.Marshal(request["params"])
 
 	s.logger.Infof("Got call %v", serviceMethod)
 
-	var response interface{}
+	s.mu.RLock()
+	editorInitialized := s.editorInitialized
+	s.mu.RUnlock()
+
+	if !editorInitialized && serviceMethod != "initialize" {
+		return newResponseError(ServerNotInitialized, "Received %s before initialize", serviceMethod)
+	}
 
 	switch serviceMethod {
 	case "initialize":
 		var params protocol.InitializeParams
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
-		s.InitializeAll(params.RootURI, params.Capabilities)
+		if err := json.Unmarshal(marshalledParams, &params); err != nil {
+			return newResponseError(InvalidParams, "Invalid initialize params: %s", err)
+		}
+
+		var encodings struct {
+			Capabilities struct {
+				General struct {
+					PositionEncodings []interface{} `json:"positionEncodings"`
+				} `json:"general"`
+			} `json:"capabilities"`
+		}
+
+		_ = json.Unmarshal(marshalledParams, &encodings)
+		positionEncoding := negotiatePositionEncoding(encodings.Capabilities.General.PositionEncodings)
+		s.mu.Lock()
+		s.positionEncoding = positionEncoding
+		s.progressSupported = params.Capabilities.Window != nil && params.Capabilities.Window.WorkDoneProgress != nil &&
+			*params.Capabilities.Window.WorkDoneProgress
+
+		s.dynamicCommands = params.Capabilities.Workspace != nil && params.Capabilities.Workspace.ExecuteCommand != nil &&
+			params.Capabilities.Workspace.ExecuteCommand.DynamicRegistration != nil &&
+			*params.Capabilities.Workspace.ExecuteCommand.DynamicRegistration
+
+		s.dynamicDocumentRequests = map[string]bool{}
+
+		if textDocument := params.Capabilities.TextDocument; textDocument != nil {
+			s.dynamicDocumentRequests["textDocument/onTypeFormatting"] = textDocument.OnTypeFormatting != nil &&
+				textDocument.OnTypeFormatting.DynamicRegistration != nil && *textDocument.OnTypeFormatting.DynamicRegistration
+			s.dynamicDocumentRequests["textDocument/willSaveWaitUntil"] = textDocument.Synchronization != nil &&
+				textDocument.Synchronization.DynamicRegistration != nil && *textDocument.Synchronization.DynamicRegistration &&
+				textDocument.Synchronization.WillSaveWaitUntil != nil && *textDocument.Synchronization.WillSaveWaitUntil
+		}
+
+		s.editorWatchesFiles = params.Capabilities.Workspace != nil && params.Capabilities.Workspace.DidChangeWatchedFiles != nil &&
+			params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration != nil &&
+			*params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+
+		if params.Trace != nil {
+			s.trace = *params.Trace
+		}
+
+		s.workspaceFolders = initialWorkspaceFolders(params)
+		s.mu.Unlock()
 
 		syncType := protocol.TextDocumentSyncKindIncremental
-		version := "0.0.1"
-		serverCaps := protocol.InitializeResult{
-			Capabilities: protocol.ServerCapabilities{
-				TextDocumentSync: &syncType,
-				CompletionProvider: &protocol.CompletionOptions{
-					TriggerCharacters: []string{",", ".", ":", "_", "-"},
-				},
-				HoverProvider:              true,
-				DefinitionProvider:         true,
-				DocumentSymbolProvider:     true,
-				CodeActionProvider:         true,
-				DocumentFormattingProvider: true,
+		enabled := true
+		serverCaps := protocol.ServerCapabilities{
+			TextDocumentSync: protocol.TextDocumentSyncOptions{
+				OpenClose: &enabled,
+				Change:    &syncType,
+				Save:      protocol.SaveOptions{},
 			},
-			ServerInfo: &protocol.InitializeResultServerInfo{
-				Name:    "proxy-ls",
-				Version: &version,
+			CompletionProvider: &protocol.CompletionOptions{
+				TriggerCharacters: []string{",", ".", ":", "_", "-"},
+				ResolveProvider:   &enabled,
 			},
-		}
-		response = makeResponse(seq, map[string]interface{}{
-			"capabilities": serverCaps,
-			"serverInfo": map[string]interface{}{
-				"name":    "proxy-ls",
-				"version": "0.1",
+			HoverProvider: true,
+			SignatureHelpProvider: &protocol.SignatureHelpOptions{
+				TriggerCharacters: []string{"(", ","},
 			},
-		})
-	case "textDocument/documentSymbol":
-		var params protocol.DocumentSymbolParams
+			DeclarationProvider:             true,
+			DefinitionProvider:              true,
+			ReferencesProvider:              true,
+			DocumentHighlightProvider:       true,
+			DocumentSymbolProvider:          true,
+			CodeActionProvider:              protocol.CodeActionOptions{ResolveProvider: &enabled},
+			CodeLensProvider:                &protocol.CodeLensOptions{ResolveProvider: &enabled},
+			DocumentLinkProvider:            &protocol.DocumentLinkOptions{ResolveProvider: &enabled},
+			DocumentFormattingProvider:      true,
+			DocumentRangeFormattingProvider: true,
+			RenameProvider:                  protocol.RenameOptions{PrepareProvider: &enabled},
+			FoldingRangeProvider:            true,
+			SelectionRangeProvider:          true,
+			LinkedEditingRangeProvider:      true,
+			CallHierarchyProvider:           true,
+		}
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		var capabilities map[string]interface{}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectRequest(n, request)
-	case "textDocument/formatting":
-		var params protocol.DocumentFormattingParams
+		marshalledCaps, _ := json.Marshal(serverCaps)
+		if err := json.Unmarshal(marshalledCaps, &capabilities); err != nil {
+			return newResponseError(InternalError, "%s", err)
+		}
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		capabilities["positionEncoding"] = positionEncoding
+		capabilities["inlayHintProvider"] = map[string]interface{}{"resolveProvider": true}
+		capabilities["workspaceSymbolProvider"] = map[string]interface{}{"resolveProvider": true}
+		capabilities["workspace"] = map[string]interface{}{
+			"workspaceFolders": map[string]interface{}{
+				"supported":           true,
+				"changeNotifications": true,
+			},
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectRequest(n, request)
-	case "textDocument/codeAction":
-		var params protocol.CodeActionParams
+		s.mu.Lock()
+		s.editorInitialized = true
+		dynamicCommands := s.dynamicCommands
+		s.mu.Unlock()
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		respond := func() error {
+			capabilities["executeCommandProvider"] = map[string]interface{}{"commands": s.staticCommands(dynamicCommands)}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectRequest(n, request)
-	case "textDocument/completion":
-		var params protocol.CompletionParams
+			return s.sendToEditor(makeResponse(seq, map[string]interface{}{
+				"capabilities": capabilities,
+				"serverInfo": map[string]interface{}{
+					"name":    "proxy-ls",
+					"version": Version,
+				},
+			}))
+		}
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		if dynamicCommands {
+			if err := respond(); err != nil {
+				return err
+			}
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectRequest(n, request)
-	case "textDocument/hover":
-		var params protocol.HoverParams
+		s.setProxySettings(initializationProxySettings(params.InitializationOptions))
+		s.loadProject()
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		if err := s.syncBackends(s.backendCommands()); err != nil {
+			s.logger.Warnf("Unable to apply the project configuration: %s", err)
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectRequest(n, request)
-	case "textDocument/declaration":
-		var params protocol.DeclarationParams
+		if s.editorWatchesFiles {
+			s.registerEditorWatcher()
+		} else {
+			s.watchFiles()
+		}
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		s.InitializeAll(params.RootURI, params.Capabilities, positionEncoding)
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectRequest(n, request)
-	case "textDocument/definition":
-		var params protocol.DefinitionParams
+		if !dynamicCommands {
+			// The commands of backends can only be advertised here, so the
+			// answer waits for them. Serve goes on to read the answers to
+			// requests of backends in the meantime.
+			go func() {
+				s.awaitBackends()
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+				if err := respond(); err != nil {
+					s.logger.Errorf("Unable to answer initialize: %s", err)
+				}
+			}()
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectRequest(n, request)
-	case "textDocument/rename":
-		var params protocol.RenameParams
+		return nil
+	case "textDocument/hover":
+		var params protocol.HoverParams
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		if err := json.Unmarshal(marshalledParams, &params); err != nil {
+			return newResponseError(InvalidParams, "Invalid %s params: %s", serviceMethod, err)
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectRequest(n, request)
-	default:
-		response = map[string]interface{}{
-			"jsonrpc": "2.0",
-			"id":      seq,
-			"error": map[string]interface{}{
-				"code":    -32601,
-				"message": "Method not found",
-			},
+		if handled, err := s.redirectEmbeddedHover(request, params); handled {
+			return err
 		}
-	}
 
-	if response == nil {
-		return
+		return s.redirectToOwner(params.TextDocument.URI, request)
+	case "proxy/status":
+		return s.sendToEditor(makeResponse(seq, s.status()))
+	case "workspace/executeCommand":
+		return s.executeCommand(request)
+	case "workspace/symbol":
+		return s.workspaceSymbols(request)
 	}
 
-	responseData, err := json.Marshal(response)
-	checkerror(err)
+	return s.routeRequest(serviceMethod, request)
+}
 
-	checkerror(s.jsonrpc.SendMessage(responseData))
+func (s *Server) redirectToOwner(uri string, request map[string]interface{}) error {
+	n, err := s.selectLSForFile(uri, "", true)
+	if err != nil {
+		return newResponseError(InvalidParams, "%s", err)
+	}
+
+	return s.redirectRequest(n, request, nil)
 }
 
-func (s *Server) selectLSForFile(name string, contents string, skipUpdate bool) string {
+func (s *Server) selectLSForFile(name string, contents string, skipUpdate bool) (string, error) {
 	if strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") {
-		isFlatpak := strings.Contains(contents, "finish-args:") && strings.Contains(contents, "modules:") &&
-			(strings.Contains(contents, "app-id:") || strings.Contains(contents, "id"))
-		if isFlatpak {
+		if isYamlFlatpakManifest(contents) {
 			parts := strings.Split(name, "/")
+			s.mu.Lock()
 			s.yamlFlatpakManifests.Insert(parts[len(parts)-1])
+			s.mu.Unlock()
 			s.logger.Infof("Found YAML flatpak manifest %s", parts[len(parts)-1])
 		}
 
@@ -447,13 +781,13 @@ func (s *Server) selectLSForFile(name string, contents string, skipUpdate bool)
 			s.updateConfigs()
 		}
 
-		return "yaml"
+		return "yaml", nil
 	} else if strings.HasSuffix(name, ".json") {
-		isFlatpak := strings.Contains(contents, "\"build-options\"") && strings.Contains(contents, "\"modules\"") && strings.Contains(contents, "\"finish-args\"") &&
-			(strings.Contains(contents, "\"app-id\"") || strings.Contains(contents, "\"id\""))
-		if isFlatpak {
+		if isJSONFlatpakManifest(contents) {
 			parts := strings.Split(name, "/")
+			s.mu.Lock()
 			s.flatpakManifests.Insert(parts[len(parts)-1])
+			s.mu.Unlock()
 			s.logger.Infof("Found flatpak manifest %s", parts[len(parts)-1])
 
 			if !skipUpdate {
@@ -461,11 +795,13 @@ func (s *Server) selectLSForFile(name string, contents string, skipUpdate bool)
 			}
 		}
 
-		return "json"
+		return "json", nil
 	} else if strings.HasSuffix(name, ".xml") || strings.HasSuffix(name, ".doap") {
 		if strings.HasSuffix(name, ".gschema.xml") {
 			parts := strings.Split(name, "/")
-			s.gschemaFiles.Insert(strings.ReplaceAll(name, "file://", ""))
+			s.mu.Lock()
+			s.gschemaFiles.Insert(uriPath(name))
+			s.mu.Unlock()
 			s.logger.Infof("Found .gschema.xml file %s", parts[len(parts)-1])
 
 			if !skipUpdate {
@@ -473,7 +809,9 @@ func (s *Server) selectLSForFile(name string, contents string, skipUpdate bool)
 			}
 		} else if strings.HasSuffix(name, ".gresource.xml") {
 			parts := strings.Split(name, "/")
-			s.gresourceFiles.Insert(strings.ReplaceAll(name, "file://", ""))
+			s.mu.Lock()
+			s.gresourceFiles.Insert(uriPath(name))
+			s.mu.Unlock()
 			s.logger.Infof("Found .gresource.xml file %s", parts[len(parts)-1])
 
 			if !skipUpdate {
@@ -481,112 +819,167 @@ func (s *Server) selectLSForFile(name string, contents string, skipUpdate bool)
 			}
 		}
 
-		return "xml"
+		return "xml", nil
 	} else if strings.HasSuffix(name, ".py") || strings.HasSuffix(name, ".pyi") {
-		return "ruff"
+		return "ruff", nil
 	} else if strings.HasSuffix(name, ".js") {
-		return "rome"
+		return "rome", nil
+	} else if strings.HasSuffix(name, ".toml") || strings.HasSuffix(name, ".toml.in") {
+		return "toml", nil
 	}
 
-	panic(name)
+	return "", fmt.Errorf("no language server handles %s", name)
 }
 
-func (s *Server) redirectNotification(id string, request map[string]interface{}) {
+func (s *Server) redirectNotification(id string, request map[string]interface{}) error {
 	s.logger.Infof("Redirecting %v to %v", request["method"], id)
-	data, _ := json.Marshal(request)
-	checkerror(s.jsonrpcs[id].SendMessage(data))
-}
 
-func (s *Server) updateConfigs() {
-	s.mu.Lock()
-	schemas := [](map[string]interface{}){
-		map[string]interface{}{
-			"uri":       "https://raw.githubusercontent.com/flatpak/flatpak-builder/main/data/flatpak-manifest.schema.json",
-			"fileMatch": s.flatpakManifests.Slice(),
-		},
-	}
-	call := makeNotification("json/schemaAssociations", []any{schemas})
-	data, _ := json.Marshal(call)
-	s.logger.Infof("json/schemaAssociations: %s", string(data))
-	checkerror(s.jsonrpcs["json"].SendMessage(data))
-
-	schemas = [](map[string]interface{}){}
-	for _, gschema := range s.gschemaFiles.Slice() {
-		schemas = append(schemas, map[string]interface{}{
-			"pattern":  gschema,
-			"systemId": "https://gitlab.gnome.org/GNOME/glib/-/raw/HEAD/gio/gschema.dtd",
-		})
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("unable to encode message for %s: %w", id, err)
 	}
 
-	for _, gresource := range s.gresourceFiles.Slice() {
-		schemas = append(schemas, map[string]interface{}{
-			"pattern":  gresource,
-			"systemId": "https://gitlab.gnome.org/GNOME/glib/-/raw/HEAD/gio/gresource.dtd",
-		})
-	}
-
-	call = makeNotification("workspace/didChangeConfiguration", map[string]interface{}{
-		"settings": map[string]interface{}{
-			"xml": xmlConfig(schemas),
-		},
-	})
-	data, _ = json.Marshal(call)
-	s.logger.Infof("workspace/didChangeConfiguration: %s", string(data))
-	checkerror(s.jsonrpcs["xml"].SendMessage(data))
-	yamlSchemas := map[string]interface{}{
-		"https://raw.githubusercontent.com/flatpak/flatpak-builder/main/data/flatpak-manifest.schema.json": s.yamlFlatpakManifests.Slice(),
-	}
-	call = makeNotification("workspace/didChangeConfiguration", map[string]interface{}{
-		"yaml": yamlConfig(yamlSchemas),
-	})
-	data, _ = json.Marshal(call)
-	s.logger.Infof("YAML: workspace/didChangeConfiguration: %s", string(data))
-	checkerror(s.jsonrpcs["yaml"].SendMessage(data))
-	s.mu.Unlock()
+	return s.forward(id, data)
 }
 
-func (s *Server) handleNotification(request map[string]interface{}) {
+func (s *Server) handleNotification(request map[string]interface{}) error {
 	serviceMethod, ok := request["method"].(string)
-	checkok(ok)
+	if !ok {
+		return fmt.Errorf("notification without method: %v", request)
+	}
+
 	s.logger.Infof("Received notification %s", serviceMethod)
 
+	s.mu.RLock()
+	editorInitialized := s.editorInitialized
+	s.mu.RUnlock()
+
+	if !editorInitialized && serviceMethod != "exit" {
+		return fmt.Errorf("dropping %s received before initialize", serviceMethod)
+	}
+
 	marshalledParams, _ := json.Marshal(request["params"])
 
 	switch serviceMethod {
 	case "textDocument/didOpen":
 		var params protocol.DidOpenTextDocumentParams
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		if err := json.Unmarshal(marshalledParams, &params); err != nil {
+			return fmt.Errorf("invalid %s params: %w", serviceMethod, err)
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, params.TextDocument.Text, false)
-		s.redirectNotification(n, request)
+		n, err := s.selectLSForFile(params.TextDocument.URI, params.TextDocument.Text, false)
+		if err != nil {
+			return err
+		}
+
+		s.documents.Open(params.TextDocument.URI, params.TextDocument.LanguageID, params.TextDocument.Version,
+			params.TextDocument.Text)
+
+		if err := s.redirectNotification(n, request); err != nil {
+			return err
+		}
 
 		s.updateConfigs()
+
+		if n == "yaml" {
+			s.updateEmbeddedDocuments(params.TextDocument.URI, params.TextDocument.Text)
+		}
 	case "textDocument/didChange":
 		var params protocol.DidChangeTextDocumentParams
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		if err := json.Unmarshal(marshalledParams, &params); err != nil {
+			return fmt.Errorf("invalid %s params: %w", serviceMethod, err)
+		}
+
+		n, err := s.selectLSForFile(params.TextDocument.URI, "", true)
+		if err != nil {
+			return err
+		}
+
+		// Read first, a backend that isn't ready gets the whole document and its
+		// encoding doesn't matter
+		syncKind := s.backendSyncKind(n)
+		text, changes, ok := s.documents.Change(params.TextDocument.URI, params.TextDocument.Version,
+			params.ContentChanges, s.editorEncoding(), s.backendEncoding(n))
+
+		switch {
+		case !ok || syncKind == protocol.TextDocumentSyncKindIncremental:
+			params.ContentChanges = changes
+		case syncKind == protocol.TextDocumentSyncKindFull:
+			params.ContentChanges = []any{protocol.TextDocumentContentChangeEventWhole{Text: text}}
+		default:
+			params.ContentChanges = nil
+		}
+
+		if params.ContentChanges != nil {
+			request["params"] = params
+			if err := s.redirectNotification(n, request); err != nil {
+				return err
+			}
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectNotification(n, request)
+		if !ok {
+			break
+		}
+
+		if s.redetectFile(params.TextDocument.URI, text) {
+			s.updateConfigs()
+		}
+
+		if n == "yaml" {
+			s.updateEmbeddedDocuments(params.TextDocument.URI, text)
+		}
 	case "textDocument/didSave":
 		var params protocol.DidSaveTextDocumentParams
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		if err := json.Unmarshal(marshalledParams, &params); err != nil {
+			return fmt.Errorf("invalid %s params: %w", serviceMethod, err)
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectNotification(n, request)
+		n, err := s.selectLSForFile(params.TextDocument.URI, "", true)
+		if err != nil {
+			return err
+		}
+
+		return s.redirectNotification(n, request)
 	case "textDocument/didClose":
 		var params protocol.DidCloseTextDocumentParams
 
-		checkerror(json.Unmarshal(marshalledParams, &params))
+		if err := json.Unmarshal(marshalledParams, &params); err != nil {
+			return fmt.Errorf("invalid %s params: %w", serviceMethod, err)
+		}
+
+		s.documents.Close(params.TextDocument.URI)
+
+		s.mu.Lock()
+		delete(s.formatting, string(params.TextDocument.URI))
+		s.mu.Unlock()
+
+		s.closeEmbeddedDocuments(params.TextDocument.URI)
+
+		n, err := s.selectLSForFile(params.TextDocument.URI, "", true)
+		if err != nil {
+			return err
+		}
 
-		n := s.selectLSForFile(params.TextDocument.URI, "", true)
-		s.redirectNotification(n, request)
+		return s.redirectNotification(n, request)
+	case "$/setTrace":
+		return s.setTrace(request)
+	case "workspace/didChangeWorkspaceFolders":
+		return s.changeWorkspaceFolders(request)
+	case "workspace/didChangeConfiguration":
+		return s.changeConfiguration(request)
+	case "workspace/didChangeWatchedFiles":
+		return s.changeWatchedFiles(request)
 	}
+
+	return nil
 }
 
 func (s *Server) Serve() {
+	defer s.stopWatching()
+
 	for {
 		messageData, err := s.jsonrpc.ReadMessage()
 		if err != nil {
@@ -595,23 +988,113 @@ func (s *Server) Serve() {
 			return
 		}
 
-		var request map[string]interface{}
-		if err := json.Unmarshal(messageData, &request); err != nil {
-			s.logger.Errorf("Error decoding request: %s\n", err)
+		requests, err := decodeMessages(messageData)
+		if err != nil {
+			// The ID of the message is unknown
+			s.logger.Errorf("Error decoding request: %s", err)
+			s.respondWithError(nil, err)
 
-			return
+			continue
 		}
 
-		if _, ok := request["error"]; ok {
-			s.logger.Warnf("Received error from editor: %v", request["error"])
+		for _, request := range requests {
+			if _, ok := request["method"]; !ok {
+				s.handleEditorResponse(request)
 
-			continue
+				continue
+			}
+
+			s.handleEditorMessage(request)
 		}
+	}
+}
 
-		if _, ok := request["id"]; ok {
-			s.handleCall(request)
-		} else {
-			s.handleNotification(request)
+// handleEditorMessage handles one message from the editor. Failed requests are
+// answered with an error response instead of taking down the proxy.
+func (s *Server) handleEditorMessage(request map[string]interface{}) {
+	seq, isRequest := request["id"]
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Errorf("Panic while handling %v: %v\n%s", request["method"], r, debug.Stack())
+
+			if isRequest {
+				s.respondWithError(seq, newResponseError(InternalError, "Internal error: %v", r))
+			}
+		}
+	}()
+
+	if !isRequest {
+		if err := s.handleNotification(request); err != nil {
+			s.logger.Warnf("Unable to handle %v: %s", request["method"], err)
 		}
+
+		return
+	}
+
+	if err := s.handleCall(request); err != nil {
+		s.logger.Warnf("Unable to handle %v: %s", request["method"], err)
+		s.respondWithError(seq, err)
+	}
+}
+
+func (s *Server) respondWithError(seq interface{}, err error) {
+	if err := s.sendToEditor(makeErrorResponse(seq, err)); err != nil {
+		s.logger.Errorf("Unable to send error response: %s", err)
+	}
+}
+
+func isYamlFlatpakManifest(contents string) bool {
+	return strings.Contains(contents, "finish-args:") && strings.Contains(contents, "modules:") &&
+		(strings.Contains(contents, "app-id:") || strings.Contains(contents, "id"))
+}
+
+func isJSONFlatpakManifest(contents string) bool {
+	return strings.Contains(contents, "\"build-options\"") && strings.Contains(contents, "\"modules\"") && strings.Contains(contents, "\"finish-args\"") &&
+		(strings.Contains(contents, "\"app-id\"") || strings.Contains(contents, "\"id\""))
+}
+
+// redetectFile updates the flatpak manifest tracking after the contents of a
+// document changed and reports whether the schema associations are outdated.
+func (s *Server) redetectFile(name string, contents string) bool {
+	var manifests *set.Set[string]
+
+	var isFlatpak bool
+
+	switch {
+	case strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml"):
+		manifests = s.yamlFlatpakManifests
+		isFlatpak = isYamlFlatpakManifest(contents)
+	case strings.HasSuffix(name, ".json"):
+		manifests = s.flatpakManifests
+		isFlatpak = isJSONFlatpakManifest(contents)
+	default:
+		return false
+	}
+
+	parts := strings.Split(name, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isFlatpak == manifests.Contains(parts[len(parts)-1]) {
+		return false
+	}
+
+	if isFlatpak {
+		s.logger.Infof("%s is now a flatpak manifest", parts[len(parts)-1])
+		manifests.Insert(parts[len(parts)-1])
+	} else {
+		s.logger.Infof("%s is no longer a flatpak manifest", parts[len(parts)-1])
+		manifests.Remove(parts[len(parts)-1])
 	}
+
+	return true
+}
+
+func (s *Server) editorEncoding() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.positionEncoding
 }
@@ -0,0 +1,1318 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestsAreRoutedWithTranslatedIDs(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"hoverProvider": true, "textDocumentSync": 1})
+	json.Responses["textDocument/hover"] = map[string]interface{}{"contents": "from json"}
+	yaml := NewFakeBackend(t, map[string]interface{}{"hoverProvider": true, "textDocumentSync": 1})
+	yaml.Responses["textDocument/hover"] = map[string]interface{}{"contents": "from yaml"}
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json, "yaml": yaml})
+	editor.Initialize()
+	editor.Open("file:///project/a.yaml", "yaml", "a: 1\n")
+
+	response := editor.Request("textDocument/hover", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.yaml"},
+		"position":     map[string]interface{}{"line": 0, "character": 0},
+	})
+
+	result, _ := response["result"].(map[string]interface{})
+	if result["contents"] != "from yaml" {
+		t.Fatalf("expected the hover of yaml, got %v", response)
+	}
+
+	if response["id"] != float64(2) {
+		t.Errorf("expected the ID of the editor, got %v", response["id"])
+	}
+
+	for _, message := range yaml.WaitFor("textDocument/hover") {
+		if message["method"] == "textDocument/hover" && message["id"] != float64(2+YamlID*LanguageServerFactor) {
+			t.Errorf("expected a translated ID, got %v", message["id"])
+		}
+	}
+
+	for _, message := range json.WaitFor("initialized") {
+		if message["method"] == "textDocument/hover" {
+			t.Errorf("json received a request for a YAML file")
+		}
+	}
+}
+
+func TestMessagesAreDeferredUntilInitialized(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"hoverProvider": true, "textDocumentSync": 1})
+	json.Initialize = make(chan struct{})
+	json.Responses["textDocument/hover"] = map[string]interface{}{"contents": "ready"}
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+	editor.InitializeWith(dynamicCommandRegistration)
+	editor.Open("file:///project/a.json", "json", "{}")
+
+	close(json.Initialize)
+
+	response := editor.Request("textDocument/hover", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.json"},
+		"position":     map[string]interface{}{"line": 0, "character": 0},
+	})
+	if result, _ := response["result"].(map[string]interface{}); result["contents"] != "ready" {
+		t.Fatalf("unexpected response %v", response)
+	}
+
+	methods := make([]interface{}, 0)
+	for _, message := range json.WaitFor("textDocument/hover") {
+		methods = append(methods, message["method"])
+	}
+
+	if fmt.Sprint(methods[:3]) != "[initialize initialized textDocument/didOpen]" {
+		t.Errorf("unexpected order %v", methods)
+	}
+}
+
+func TestChangesBeforeInitializationAreDeferred(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{
+		"hoverProvider":    true,
+		"textDocumentSync": 2,
+		"positionEncoding": PositionEncodingUTF8,
+	})
+	json.Initialize = make(chan struct{})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+	editor.InitializeWith(dynamicCommandRegistration)
+	editor.Open("file:///project/a.json", "json", "{}\n")
+	editor.Notify("textDocument/didChange", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.json", "version": 2},
+		"contentChanges": []interface{}{map[string]interface{}{
+			"range": map[string]interface{}{
+				"start": map[string]interface{}{"line": 0, "character": 1},
+				"end":   map[string]interface{}{"line": 0, "character": 1},
+			},
+			"text": `"😀": 1`,
+		}},
+	})
+
+	hovered := make(chan struct{})
+
+	go func() {
+		defer close(hovered)
+
+		// The emoji is two UTF-16 code units and four bytes
+		editor.Request("textDocument/hover", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": "file:///project/a.json"},
+			"position":     map[string]interface{}{"line": 0, "character": 5},
+		})
+	}()
+
+	// Released once the hover request waits for the backend
+	deadline := time.Now().Add(testTimeout)
+
+	for pending, delay := 0.0, time.Millisecond; pending == 0; delay *= 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the hover request never waited for json")
+		}
+
+		time.Sleep(delay)
+
+		status, _ := editor.Request("proxy/status", nil)["result"].(map[string]interface{})
+		backends, _ := status["backends"].([]interface{})
+
+		for _, backend := range backends {
+			if backend, _ := backend.(map[string]interface{}); backend["id"] == "json" {
+				pending, _ = backend["pendingRequests"].(float64)
+			}
+		}
+	}
+
+	close(json.Initialize)
+	<-hovered
+
+	for _, message := range json.WaitFor("textDocument/hover") {
+		params, _ := message["params"].(map[string]interface{})
+
+		switch message["method"] {
+		case "textDocument/didChange":
+			if changes := fmt.Sprint(params["contentChanges"]); changes != `[map[text:{"😀": 1}
+]]` {
+				t.Errorf("expected the whole document, got %v", changes)
+			}
+		case "textDocument/hover":
+			if position := fmt.Sprint(params["position"]); position != "map[character:7 line:0]" {
+				t.Errorf("expected a UTF-8 position, got %v", position)
+			}
+		}
+	}
+}
+
+func TestChangesFollowTheSyncKindOfBackends(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 2, "positionEncoding": PositionEncodingUTF8})
+	yaml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": map[string]interface{}{"openClose": true, "change": 1}})
+	xml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 0})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json, "yaml": yaml, "xml": xml})
+	editor.Initialize()
+
+	for _, backend := range []*FakeBackend{json, yaml, xml} {
+		backend.WaitFor("initialized")
+	}
+
+	for _, uri := range []string{"file:///project/a.json", "file:///project/a.yaml", "file:///project/a.xml"} {
+		editor.Open(uri, "", mixedLine+"\n")
+		editor.Notify("textDocument/didChange", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []interface{}{map[string]interface{}{
+				// Replaces 漢
+				"range": map[string]interface{}{
+					"start": map[string]interface{}{"line": 0, "character": 3},
+					"end":   map[string]interface{}{"line": 0, "character": 4},
+				},
+				"text": "中",
+			}},
+		})
+		editor.Request("textDocument/hover", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": 0, "character": 0},
+		})
+	}
+
+	for backend, expected := range map[*FakeBackend]string{
+		json: "[map[range:map[end:map[character:8 line:0] start:map[character:5 line:0]] text:中]]",
+		yaml: "[map[text:a😀中b\n]]",
+	} {
+		changes := filterMethod(backend.WaitFor("textDocument/hover"), "textDocument/didChange")
+		if len(changes) != 1 {
+			t.Fatalf("expected one change, got %v", changes)
+		}
+
+		params, _ := changes[0]["params"].(map[string]interface{})
+		if result := fmt.Sprint(params["contentChanges"]); result != expected {
+			t.Errorf("expected %s, got %s", expected, result)
+		}
+	}
+
+	if changes := filterMethod(xml.WaitFor("textDocument/hover"), "textDocument/didChange"); len(changes) != 0 {
+		t.Errorf("expected no changes for a backend without sync, got %v", changes)
+	}
+}
+
+func TestBackendsAreStoppedWhenInitializeTimesOut(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{
+		"textDocumentSync":       1,
+		"executeCommandProvider": map[string]interface{}{"commands": []string{"json.sort"}},
+	})
+	json.Initialize = make(chan struct{})
+
+	editor := newTestServerWithOptions(t, Options{
+		InitializeTimeout: 50 * time.Millisecond,
+		Backends: func(id string, _ string) (*JSONRPC, error) {
+			if id != "json" {
+				return nil, fmt.Errorf("not installed")
+			}
+
+			return json.connect(), nil
+		},
+	})
+	editor.Request("initialize", map[string]interface{}{
+		"rootUri":      "file:///project",
+		"capabilities": map[string]interface{}{"window": map[string]interface{}{"workDoneProgress": true}},
+	})
+	editor.WaitForNotification("$/progress", func(params map[string]interface{}) bool {
+		value, _ := params["value"].(map[string]interface{})
+		message, _ := value["message"].(string)
+
+		return value["kind"] == "end" && strings.Contains(message, "did not initialize")
+	})
+
+	// Answered late, after the backend was stopped and its connection closed
+	editor.server.mu.RLock()
+	backend := editor.server.backends["json"]
+	editor.server.mu.RUnlock()
+
+	if err := editor.server.handleLSResponse(makeResponse(1, map[string]interface{}{"capabilities": json.Capabilities}), backend); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ := editor.Request("proxy/status", nil)["result"].(map[string]interface{})
+	backends, _ := status["backends"].([]interface{})
+
+	for _, backend := range backends {
+		if backend, _ := backend.(map[string]interface{}); backend["id"] == "json" {
+			if backend["state"] != BackendCrashed || backend["commands"] != nil {
+				t.Errorf("expected json to stay crashed, got %v", backend)
+			}
+		}
+	}
+
+	if initialized := filterMethod(json.WaitFor("initialize"), "initialized"); len(initialized) != 0 {
+		t.Errorf("expected the late response to be ignored, got %v", initialized)
+	}
+}
+
+func TestRequestsFailWithoutBackend(t *testing.T) {
+	editor := newTestServer(t, map[string]*FakeBackend{})
+	editor.Initialize()
+
+	response := editor.Request("textDocument/hover", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.json"},
+		"position":     map[string]interface{}{"line": 0, "character": 0},
+	})
+
+	responseError, _ := response["error"].(map[string]interface{})
+	if responseError["code"] != float64(RequestFailed) {
+		t.Fatalf("expected RequestFailed, got %v", response)
+	}
+}
+
+func TestMalformedMessagesAreAnswered(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"hoverProvider": true, "textDocumentSync": 1})
+	json.Responses["textDocument/hover"] = map[string]interface{}{"contents": "still there"}
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+	editor.Initialize()
+	editor.Open("file:///project/a.json", "json", "{}")
+	json.WaitFor("textDocument/didOpen")
+
+	for data, code := range map[string]int{`{"jsonrpc": "2.0", "id": `: ParseError, `[]`: InvalidRequest, `42`: InvalidRequest} {
+		response := editor.SendRaw(data)
+
+		responseError, _ := response["error"].(map[string]interface{})
+		if _, hasID := response["id"]; !hasID || response["id"] != nil || responseError["code"] != float64(code) {
+			t.Errorf("expected %q to be answered with %d and a null ID, got %v", data, code, response)
+		}
+	}
+
+	// Skipped by the proxy, the backend keeps being read
+	json.SendRaw(`{"jsonrpc": "2.0", "method": `)
+
+	response := editor.Request("textDocument/hover", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.json"},
+		"position":     map[string]interface{}{"line": 0, "character": 0},
+	})
+	if result, _ := response["result"].(map[string]interface{}); result["contents"] != "still there" {
+		t.Errorf("expected the proxy and the backend to keep working, got %v", response)
+	}
+}
+
+func TestPanicsAreAnsweredWithInternalError(t *testing.T) {
+	editor := newTestServerWithOptions(t, Options{
+		Backends: func(string, string) (*JSONRPC, error) {
+			return nil, fmt.Errorf("not installed")
+		},
+		Reload: func() (Options, error) {
+			panic("reload failed")
+		},
+	})
+	editor.Initialize()
+
+	response := editor.Request("workspace/executeCommand", map[string]interface{}{"command": ReloadConfigCommand})
+	if responseError, _ := response["error"].(map[string]interface{}); responseError["code"] != float64(InternalError) {
+		t.Errorf("expected InternalError, got %v", response)
+	}
+
+	if response := editor.Request("proxy/status", nil); response["result"] == nil {
+		t.Errorf("expected the proxy to keep working, got %v", response)
+	}
+}
+
+func TestConfigurationIsAnswered(t *testing.T) {
+	yaml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"yaml": yaml})
+	editor.Initialize()
+	yaml.WaitFor("initialized")
+
+	response := yaml.Request("workspace/configuration", map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"section": "[yaml]"},
+			map[string]interface{}{"section": "xml.format.tabSize"},
+			map[string]interface{}{"section": "unknown"},
+		},
+	})
+
+	result, _ := response["result"].([]interface{})
+	if len(result) != 3 {
+		t.Fatalf("expected one answer per item, got %v", response)
+	}
+
+	if settings, _ := result[0].(map[string]interface{}); settings["editor.tabSize"] != float64(DefaultTabSize) {
+		t.Errorf("unexpected [yaml] settings %v", result[0])
+	}
+
+	if result[1] != float64(DefaultTabSize) || result[2] != nil {
+		t.Errorf("unexpected answers %v", result)
+	}
+
+	response = yaml.Request("workspace/unknown", nil)
+	if responseError, _ := response["error"].(map[string]interface{}); responseError["code"] != float64(MethodNotFound) {
+		t.Errorf("expected MethodNotFound, got %v", response)
+	}
+}
+
+func TestTOMLFilesAreRoutedToTaplo(t *testing.T) {
+	toml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"toml": toml})
+	editor.Initialize()
+	toml.WaitFor("initialized")
+
+	uris := []string{
+		"file:///project/pyproject.toml",
+		"file:///project/ruff.toml",
+		"file:///project/.ruff.toml",
+		"file:///project/docs/project.toml.in",
+	}
+	for _, uri := range uris {
+		editor.Open(uri, "toml", "a = 1\n")
+	}
+
+	// go.mod isn't TOML
+	editor.Open("file:///project/go.mod", "go.mod", "module example.com/project\n")
+	editor.Open("file:///project/Cargo.toml", "toml", "[package]\n")
+
+	opened := filterMethod(toml.WaitForCount("textDocument/didOpen", len(uris)+1), "textDocument/didOpen")
+	for i, message := range opened {
+		params, _ := message["params"].(map[string]interface{})
+		document, _ := params["textDocument"].(map[string]interface{})
+
+		expected := "file:///project/Cargo.toml"
+		if i < len(uris) {
+			expected = uris[i]
+		}
+
+		if document["uri"] != expected {
+			t.Errorf("expected taplo to be sent %s, got %v", expected, document["uri"])
+		}
+	}
+
+	response := toml.Request("workspace/configuration", map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"section": "evenBetterToml.schema"}},
+	})
+
+	result, _ := response["result"].([]interface{})
+	if len(result) != 1 {
+		t.Fatalf("expected one answer, got %v", response)
+	}
+
+	schema, _ := result[0].(map[string]interface{})
+	associations, _ := schema["associations"].(map[string]interface{})
+
+	for pattern, url := range map[string]string{
+		`/Cargo\.toml$`:     "https://json.schemastore.org/cargo.json",
+		`/pyproject\.toml$`: "https://json.schemastore.org/pyproject.json",
+		`/\.?ruff\.toml$`:   "https://json.schemastore.org/ruff.json",
+	} {
+		if associations[pattern] != url {
+			t.Errorf("expected %s to be associated with %s, got %v", pattern, url, associations[pattern])
+		}
+	}
+
+	if schema["enabled"] != true {
+		t.Errorf("expected schemas to be enabled, got %v", schema)
+	}
+}
+
+func TestDiagnosticsOfEmbeddedScriptsAreMerged(t *testing.T) {
+	yaml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+	bash := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"yaml": yaml, "bash": bash})
+	editor.Initialize()
+
+	uri := "file:///project/.github/workflows/ci.yml"
+	editor.Open(uri, "yaml", "jobs:\n  build:\n    steps:\n      - run: |\n          echo $foo\n")
+
+	var script string
+
+	for _, message := range bash.WaitFor("textDocument/didOpen") {
+		if message["method"] == "textDocument/didOpen" {
+			script = documentURI(message["params"])
+		}
+	}
+
+	diagnostic := func(line int, message string) map[string]interface{} {
+		return map[string]interface{}{
+			"range": map[string]interface{}{
+				"start": map[string]interface{}{"line": line, "character": 5},
+				"end":   map[string]interface{}{"line": line, "character": 9},
+			},
+			"message": message,
+		}
+	}
+
+	yaml.Notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": []interface{}{diagnostic(1, "from yaml")},
+	})
+	bash.Notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         script,
+		"diagnostics": []interface{}{diagnostic(0, "from bash")},
+	})
+
+	params := editor.WaitForNotification("textDocument/publishDiagnostics", func(params map[string]interface{}) bool {
+		diagnostics, _ := params["diagnostics"].([]interface{})
+
+		return params["uri"] == uri && len(diagnostics) == 2
+	})
+
+	diagnostics, _ := params["diagnostics"].([]interface{})
+	embedded, _ := diagnostics[1].(map[string]interface{})
+	start, _ := embedded["range"].(map[string]interface{})["start"].(map[string]interface{})
+
+	if embedded["message"] != "from bash" || start["line"] != float64(4) || start["character"] != float64(15) {
+		t.Errorf("embedded diagnostic was not mapped into the workflow: %v", embedded)
+	}
+}
+
+func TestMessagesAreForwardedWithBackendName(t *testing.T) {
+	yaml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"yaml": yaml})
+	editor.Initialize()
+	yaml.WaitFor("initialized")
+
+	yaml.Notify("window/logMessage", map[string]interface{}{"type": 4, "message": "debug output"})
+	yaml.Notify("window/logMessage", map[string]interface{}{"type": 1, "message": "schema could not be loaded"})
+
+	params := editor.WaitForNotification("window/logMessage", func(map[string]interface{}) bool {
+		return true
+	})
+	if params["message"] != "[yaml-language-server] schema could not be loaded" {
+		t.Errorf("expected only the error to be forwarded, got %v", params)
+	}
+
+	editor.Notify("$/setTrace", map[string]interface{}{"value": "verbose"})
+	yaml.WaitFor("$/setTrace")
+}
+
+func TestRestartBackendOpensDocumentsAgain(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+	editor.Initialize()
+	editor.Open("file:///project/a.json", "json", "{}")
+	json.WaitFor("textDocument/didOpen")
+
+	response := editor.Request("workspace/executeCommand", map[string]interface{}{
+		"command":   RestartBackendCommand,
+		"arguments": []interface{}{"json"},
+	})
+	if response["error"] != nil {
+		t.Fatalf("restart failed: %v", response["error"])
+	}
+
+	json.WaitForCount("initialize", 2)
+
+	for _, message := range json.WaitForCount("textDocument/didOpen", 2) {
+		if message["method"] != "textDocument/didOpen" {
+			continue
+		}
+
+		params, _ := message["params"].(map[string]interface{})
+		document, _ := params["textDocument"].(map[string]interface{})
+
+		if document["uri"] != "file:///project/a.json" || document["languageId"] != "json" || document["text"] != "{}" {
+			t.Errorf("expected a.json to be opened again, got %v", document)
+		}
+	}
+
+	response = editor.Request("workspace/executeCommand", map[string]interface{}{
+		"command":   RestartBackendCommand,
+		"arguments": []interface{}{"cobol"},
+	})
+	if response["error"] == nil {
+		t.Errorf("expected an error restarting an unknown backend")
+	}
+}
+
+func TestStatusReportsBackends(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+	editor.Initialize()
+	editor.Open("file:///project/a.json", "json", "{}")
+	json.WaitFor("textDocument/didOpen")
+
+	response := editor.Request("proxy/status", nil)
+
+	result, _ := response["result"].(map[string]interface{})
+	backends, _ := result["backends"].([]interface{})
+
+	if result["version"] != Version || len(backends) != len(defaultBackends) {
+		t.Fatalf("expected the status of every backend, got %v", response)
+	}
+
+	for _, backend := range backends {
+		backend, _ := backend.(map[string]interface{})
+
+		switch backend["id"] {
+		case "json":
+			documents, _ := backend["openDocuments"].([]interface{})
+			if backend["state"] != BackendReady || len(documents) != 1 || documents[0] != "file:///project/a.json" {
+				t.Errorf("expected json to be ready with a.json open, got %v", backend)
+			}
+		case "xml":
+			if backend["state"] != BackendCrashed || backend["lastError"] == nil {
+				t.Errorf("expected xml to have crashed, got %v", backend)
+			}
+		}
+	}
+}
+
+func TestCommandsAreRoutedToTheirBackend(t *testing.T) {
+	xml := NewFakeBackend(t, map[string]interface{}{
+		"textDocumentSync":       1,
+		"executeCommandProvider": map[string]interface{}{"commands": []string{"shared.fix"}},
+	})
+	xml.Responses["workspace/executeCommand"] = "from xml"
+	json := NewFakeBackend(t, map[string]interface{}{
+		"textDocumentSync":       1,
+		"codeActionProvider":     true,
+		"executeCommandProvider": map[string]interface{}{"commands": []string{"shared.fix"}},
+	})
+	json.Initialize = make(chan struct{})
+	json.Responses["workspace/executeCommand"] = "from json"
+	json.Responses["textDocument/codeAction"] = []interface{}{
+		map[string]interface{}{"title": "Fix", "command": "shared.fix"},
+	}
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json, "xml": xml})
+	editor.InitializeWith(dynamicCommandRegistration)
+	xml.WaitFor("initialized")
+	close(json.Initialize)
+	json.WaitFor("initialized")
+	editor.Open("file:///project/a.json", "json", "{}")
+
+	response := editor.Request("textDocument/codeAction", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.json"},
+		"range": map[string]interface{}{
+			"start": map[string]interface{}{"line": 0, "character": 0},
+			"end":   map[string]interface{}{"line": 0, "character": 0},
+		},
+		"context": map[string]interface{}{"diagnostics": []interface{}{}},
+	})
+
+	actions, _ := response["result"].([]interface{})
+	if len(actions) != 1 {
+		t.Fatalf("expected one code action, got %v", response)
+	}
+
+	if action, _ := actions[0].(map[string]interface{}); action["command"] != "json.shared.fix" {
+		t.Errorf("expected the command of json to be prefixed, got %v", action["command"])
+	}
+
+	status, _ := editor.Request("proxy/status", nil)["result"].(map[string]interface{})
+	backends, _ := status["backends"].([]interface{})
+
+	for _, backend := range backends {
+		backend, _ := backend.(map[string]interface{})
+		if backend["id"] == "json" || backend["id"] == "xml" {
+			if commands := fmt.Sprint(backend["commands"]); commands != fmt.Sprintf("[%s.shared.fix]", backend["id"]) {
+				t.Errorf("expected the command of %s to be prefixed, got %v", backend["id"], commands)
+			}
+		}
+	}
+
+	for command, expected := range map[string]string{"xml.shared.fix": "from xml", "json.shared.fix": "from json"} {
+		response := editor.Request("workspace/executeCommand", map[string]interface{}{"command": command})
+		if response["result"] != expected {
+			t.Errorf("expected %s to be answered %s, got %v", command, expected, response)
+		}
+	}
+
+	for _, message := range json.WaitFor("workspace/executeCommand") {
+		params, _ := message["params"].(map[string]interface{})
+		if message["method"] == "workspace/executeCommand" && params["command"] != "shared.fix" {
+			t.Errorf("expected json to receive its own command name, got %v", params["command"])
+		}
+	}
+
+	for _, command := range []string{"unknown", "shared.fix"} {
+		response = editor.Request("workspace/executeCommand", map[string]interface{}{"command": command})
+		if response["error"] == nil {
+			t.Errorf("expected an error for the unknown command %s", command)
+		}
+	}
+}
+
+func TestResolveIsRoutedByTaggedData(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1, "completionProvider": map[string]interface{}{}})
+	json.Responses["textDocument/completion"] = map[string]interface{}{
+		"isIncomplete": false,
+		"items":        []interface{}{map[string]interface{}{"label": "a", "data": map[string]interface{}{"n": 1}}},
+	}
+	json.Responses["completionItem/resolve"] = map[string]interface{}{"label": "a", "documentation": "resolved"}
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+	editor.Initialize()
+	editor.Open("file:///project/a.json", "json", "{}")
+
+	response := editor.Request("textDocument/completion", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.json"},
+		"position":     map[string]interface{}{"line": 0, "character": 1},
+	})
+
+	list, _ := response["result"].(map[string]interface{})
+	items, _ := list["items"].([]interface{})
+
+	if len(items) != 1 {
+		t.Fatalf("expected one completion item, got %v", response)
+	}
+
+	response = editor.Request("completionItem/resolve", items[0])
+
+	result, _ := response["result"].(map[string]interface{})
+	if result["documentation"] != "resolved" {
+		t.Fatalf("expected the item resolved by json, got %v", response)
+	}
+
+	if data, _ := result["data"].(map[string]interface{}); data[ResolveBackendKey] != "json" {
+		t.Errorf("expected the resolved item to be tagged again, got %v", result["data"])
+	}
+
+	for _, message := range json.WaitFor("completionItem/resolve") {
+		if message["method"] != "completionItem/resolve" {
+			continue
+		}
+
+		params, _ := message["params"].(map[string]interface{})
+		if data, _ := params["data"].(map[string]interface{}); data["n"] != float64(1) {
+			t.Errorf("expected json to receive its original data, got %v", params["data"])
+		}
+	}
+
+	response = editor.Request("codeAction/resolve", map[string]interface{}{"title": "untagged"})
+	if response["error"] == nil {
+		t.Errorf("expected an error resolving an untagged code action")
+	}
+}
+
+func TestDocumentRequestsAreRoutedGenerically(t *testing.T) {
+	xml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+	xml.Responses["textDocument/linkedEditingRange"] = map[string]interface{}{"ranges": []interface{}{}}
+	xml.Responses["callHierarchy/incomingCalls"] = []interface{}{}
+
+	editor := newTestServer(t, map[string]*FakeBackend{"xml": xml})
+	editor.Initialize()
+	editor.Open("file:///project/a.xml", "xml", "<a></a>")
+
+	response := editor.Request("textDocument/linkedEditingRange", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.xml"},
+		"position":     map[string]interface{}{"line": 0, "character": 1},
+	})
+	if response["error"] != nil || response["result"] == nil {
+		t.Errorf("expected linkedEditingRange to be answered by xml, got %v", response)
+	}
+
+	response = editor.Request("callHierarchy/incomingCalls", map[string]interface{}{
+		"item": map[string]interface{}{"name": "a", "uri": "file:///project/a.xml"},
+	})
+	if response["error"] != nil {
+		t.Errorf("expected incomingCalls to be answered by xml, got %v", response)
+	}
+
+	xml.WaitFor("callHierarchy/incomingCalls")
+
+	response = editor.Request("workspace/unknown", map[string]interface{}{})
+	if responseError, _ := response["error"].(map[string]interface{}); responseError["code"] != float64(MethodNotFound) {
+		t.Errorf("expected MethodNotFound, got %v", response)
+	}
+}
+
+func TestWorkspaceSymbolsAreMerged(t *testing.T) {
+	symbol := func(name string, uri string) []interface{} {
+		return []interface{}{map[string]interface{}{
+			"name":     name,
+			"kind":     12,
+			"location": map[string]interface{}{"uri": uri},
+		}}
+	}
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1, "workspaceSymbolProvider": true})
+	json.Responses["workspace/symbol"] = symbol("fromJSON", "file:///project/a.json")
+	json.Responses["workspaceSymbol/resolve"] = map[string]interface{}{"name": "resolved"}
+	xml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1, "workspaceSymbolProvider": map[string]interface{}{}})
+	xml.Responses["workspace/symbol"] = symbol("fromXML", "file:///project/a.xml")
+	yaml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json, "xml": xml, "yaml": yaml})
+	editor.Initialize()
+	json.WaitFor("initialized")
+	xml.WaitFor("initialized")
+	yaml.WaitFor("initialized")
+
+	response := editor.Request("workspace/symbol", map[string]interface{}{"query": "from"})
+
+	symbols, _ := response["result"].([]interface{})
+	if len(symbols) != 2 {
+		t.Fatalf("expected the symbols of json and xml, got %v", response)
+	}
+
+	for _, symbol := range symbols {
+		if symbol, _ := symbol.(map[string]interface{}); symbol["name"] == "fromJSON" {
+			response = editor.Request("workspaceSymbol/resolve", symbol)
+		}
+	}
+
+	if result, _ := response["result"].(map[string]interface{}); result["name"] != "resolved" {
+		t.Errorf("expected the symbol resolved by json, got %v", response)
+	}
+
+	response = editor.Request("workspace/symbol", map[string]interface{}{"query": "from", "partialResultToken": "partial"})
+	if symbols, _ := response["result"].([]interface{}); len(symbols) != 0 {
+		t.Errorf("expected all symbols to be sent as partial results, got %v", response)
+	}
+
+	for i := 0; i < 2; i++ {
+		editor.WaitForNotification("$/progress", func(params map[string]interface{}) bool {
+			value, _ := params["value"].([]interface{})
+
+			return params["token"] == "partial" && len(value) == 1
+		})
+	}
+}
+
+func TestWorkspaceFoldersArePassedThrough(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+	editor.Request("initialize", map[string]interface{}{
+		"rootUri":      "file:///a",
+		"capabilities": map[string]interface{}{},
+		"workspaceFolders": []interface{}{
+			map[string]interface{}{"uri": "file:///a", "name": "a"},
+			map[string]interface{}{"uri": "file:///b", "name": "b"},
+		},
+	})
+
+	for _, message := range json.WaitFor("initialized") {
+		if message["method"] != "initialize" {
+			continue
+		}
+
+		params, _ := message["params"].(map[string]interface{})
+		if folders, _ := params["workspaceFolders"].([]interface{}); len(folders) != 2 {
+			t.Errorf("expected both folders on initialize, got %v", params["workspaceFolders"])
+		}
+	}
+
+	editor.Notify("workspace/didChangeWorkspaceFolders", map[string]interface{}{
+		"event": map[string]interface{}{
+			"added":   []interface{}{map[string]interface{}{"uri": "file:///c", "name": "c"}},
+			"removed": []interface{}{map[string]interface{}{"uri": "file:///a", "name": "a"}},
+		},
+	})
+	json.WaitFor("workspace/didChangeWorkspaceFolders")
+
+	response := json.Request("workspace/workspaceFolders", nil)
+	folders, _ := response["result"].([]interface{})
+
+	if len(folders) != 2 || fmt.Sprint(folders) != "[map[name:b uri:file:///b] map[name:c uri:file:///c]]" {
+		t.Errorf("expected b and c to be open, got %v", response)
+	}
+}
+
+func TestProjectConfigurationIsApplied(t *testing.T) {
+	directory := t.TempDir()
+	project := `{"disabled": ["xml"], "settings": {"rome": {"unstable": false}}}`
+
+	if err := os.WriteFile(filepath.Join(directory, ".proxy-ls.json"), []byte(project), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rome := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+	xml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"rome": rome, "xml": xml})
+	editor.Request("initialize", map[string]interface{}{
+		"rootUri":      "file://" + directory,
+		"capabilities": map[string]interface{}{},
+	})
+	rome.WaitFor("initialized")
+
+	response := rome.Request("workspace/configuration", map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"section": "rome"}},
+	})
+
+	result, _ := response["result"].([]interface{})
+	if len(result) != 1 {
+		t.Fatalf("expected one configuration, got %v", response)
+	}
+
+	if settings, _ := result[0].(map[string]interface{}); settings["unstable"] != false || settings["rename"] != true {
+		t.Errorf("expected the project settings merged into the defaults, got %v", settings)
+	}
+
+	status := editor.server.status()
+	for _, backend := range status.Backends {
+		if backend.ID == "xml" && backend.State != BackendDisabled {
+			t.Errorf("expected xml to be disabled by the project, got %v", backend)
+		}
+	}
+}
+
+func TestSettingsAreResolvedPerScope(t *testing.T) {
+	directory := t.TempDir()
+	project := `{"scopes": {"sub": {"python": {"analysis": {"typeCheckingMode": "off"}}}}}`
+
+	if err := os.WriteFile(filepath.Join(directory, ".proxy-ls.json"), []byte(project), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ruff := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+	rome := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"ruff": ruff, "rome": rome})
+	editor.Request("initialize", map[string]interface{}{
+		"rootUri":      "file://" + directory,
+		"capabilities": map[string]interface{}{},
+	})
+	ruff.WaitFor("initialized")
+	rome.WaitFor("initialized")
+
+	response := ruff.Request("workspace/configuration", map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"section": "python.analysis.typeCheckingMode", "scopeUri": "file://" + directory + "/sub/a.py"},
+			map[string]interface{}{"section": "python.analysis.typeCheckingMode", "scopeUri": "file://" + directory + "/a.py"},
+			map[string]interface{}{"section": "xml.format.tabSize"},
+		},
+	})
+	if result := fmt.Sprint(response["result"]); result != fmt.Sprintf("[off strict %d]", DefaultTabSize) {
+		t.Errorf("expected the scoped settings, got %v", result)
+	}
+
+	editor.Notify("workspace/didChangeConfiguration", map[string]interface{}{
+		"settings": map[string]interface{}{"rome": map[string]interface{}{"unstable": false}},
+	})
+
+	for _, message := range rome.WaitFor("workspace/didChangeConfiguration") {
+		if message["method"] != "workspace/didChangeConfiguration" {
+			continue
+		}
+
+		params, _ := message["params"].(map[string]interface{})
+		if settings := fmt.Sprint(params["settings"]); settings != "map[rome:map[rename:true require_configuration:true unstable:false]]" {
+			t.Errorf("expected the settings pushed by the editor, got %v", settings)
+		}
+	}
+
+	for _, message := range ruff.WaitFor("initialized") {
+		if message["method"] == "workspace/didChangeConfiguration" {
+			t.Errorf("ruff was notified about settings it doesn't read")
+		}
+	}
+}
+
+func TestFormattingFollowsEditorConfig(t *testing.T) {
+	directory := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(directory, ".editorconfig"), []byte("root = true\n\n[*.xml]\nindent_size = 4\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	xml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1, "documentFormattingProvider": true})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"xml": xml})
+	editor.Initialize()
+	xml.WaitFor("initialized")
+
+	uri := "file://" + directory + "/a.xml"
+	editor.Open(uri, "xml", "<a/>")
+	editor.Request("textDocument/formatting", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"options":      map[string]interface{}{"tabSize": 8, "insertSpaces": false},
+	})
+
+	for _, message := range xml.WaitFor("textDocument/formatting") {
+		if message["method"] != "textDocument/formatting" {
+			continue
+		}
+
+		params, _ := message["params"].(map[string]interface{})
+		if options := fmt.Sprint(params["options"]); options != "map[insertSpaces:false tabSize:4]" {
+			t.Errorf("expected the indentation of .editorconfig, got %v", options)
+		}
+	}
+
+	response := xml.Request("workspace/configuration", map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"section": "xml.format", "scopeUri": uri},
+			map[string]interface{}{"section": "xml.format.tabSize"},
+		},
+	})
+	if result := fmt.Sprint(response["result"]); result != fmt.Sprintf("[map[insertSpaces:false tabSize:4] %d]", DefaultTabSize) {
+		t.Errorf("expected the indentation of the document, got %v", result)
+	}
+}
+
+func TestBackendCommandsAreAdvertisedWithoutDynamicRegistration(t *testing.T) {
+	for _, test := range []struct {
+		capabilities map[string]interface{}
+		expected     []string
+	}{
+		{map[string]interface{}{}, append(append([]string{}, proxyCommands...), "json.sort")},
+		// Registered once json is ready instead
+		{dynamicCommandRegistration, proxyCommands},
+	} {
+		json := NewFakeBackend(t, map[string]interface{}{
+			"executeCommandProvider": map[string]interface{}{"commands": []string{"json.sort"}},
+		})
+
+		editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+		response := editor.Request("initialize", map[string]interface{}{
+			"rootUri":      "file:///project",
+			"capabilities": test.capabilities,
+		})
+
+		result, _ := response["result"].(map[string]interface{})
+		capabilities, _ := result["capabilities"].(map[string]interface{})
+		provider, _ := capabilities["executeCommandProvider"].(map[string]interface{})
+
+		if commands := fmt.Sprint(provider["commands"]); commands != fmt.Sprint(test.expected) {
+			t.Errorf("%v: expected %v, got %s", test.capabilities, test.expected, commands)
+		}
+	}
+}
+
+func TestBackendRequestsAreForwardedToTheEditor(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{
+		"textDocumentSync": 1,
+		"positionEncoding": PositionEncodingUTF8,
+	})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json})
+	editor.Responses["workspace/applyEdit"] = map[string]interface{}{"applied": true}
+	editor.Responses["window/showMessageRequest"] = map[string]interface{}{"title": "Yes"}
+	editor.Initialize()
+	editor.Open("file:///project/a.json", "json", `{"😀": 1}`)
+	json.WaitFor("textDocument/didOpen")
+
+	// The 1 is nine bytes or seven UTF-16 code units into the line
+	edit := map[string]interface{}{
+		"edit": map[string]interface{}{
+			"changes": map[string]interface{}{
+				"file:///project/a.json": []interface{}{map[string]interface{}{
+					"range": map[string]interface{}{
+						"start": map[string]interface{}{"line": 0, "character": 9},
+						"end":   map[string]interface{}{"line": 0, "character": 10},
+					},
+					"newText": "2",
+				}},
+			},
+		},
+	}
+
+	response := json.Request("workspace/applyEdit", edit)
+	if result, _ := response["result"].(map[string]interface{}); result["applied"] != true {
+		t.Errorf("expected the answer of the editor, got %v", response)
+	}
+
+	editor.WaitForNotification("workspace/applyEdit", func(params map[string]interface{}) bool {
+		changes := fmt.Sprint(params["edit"].(map[string]interface{})["changes"])
+		if changes != "map[file:///project/a.json:[map[newText:2 range:map[end:map[character:8 line:0] start:map[character:7 line:0]]]]]" {
+			t.Errorf("expected UTF-16 positions, got %s", changes)
+		}
+
+		return true
+	})
+
+	response = json.Request("window/showMessageRequest", map[string]interface{}{
+		"type":    3,
+		"message": "Continue?",
+		"actions": []interface{}{map[string]interface{}{"title": "Yes"}},
+	})
+	if result, _ := response["result"].(map[string]interface{}); result["title"] != "Yes" {
+		t.Errorf("expected the chosen action, got %v", response)
+	}
+
+	for _, method := range []string{"window/workDoneProgress/create", "window/showDocument"} {
+		if response := json.Request(method, map[string]interface{}{"token": "t", "uri": "file:///project/a.json"}); response["error"] != nil {
+			t.Errorf("expected %s to be answered by the editor, got %v", method, response)
+		}
+
+		editor.WaitForNotification(method, func(map[string]interface{}) bool { return true })
+	}
+}
+
+func TestCollidingCommandsAreRegisteredAgain(t *testing.T) {
+	xml := NewFakeBackend(t, map[string]interface{}{
+		"textDocumentSync":       1,
+		"executeCommandProvider": map[string]interface{}{"commands": []string{"shared.fix", "xml.validate"}},
+	})
+	json := NewFakeBackend(t, map[string]interface{}{
+		"textDocumentSync":       1,
+		"executeCommandProvider": map[string]interface{}{"commands": []string{"shared.fix"}},
+	})
+	json.Initialize = make(chan struct{})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json, "xml": xml})
+	editor.Request("initialize", map[string]interface{}{
+		"rootUri": "file:///project",
+		"capabilities": map[string]interface{}{
+			"workspace": map[string]interface{}{"executeCommand": map[string]interface{}{"dynamicRegistration": true}},
+		},
+	})
+	xml.WaitFor("initialized")
+
+	registered := func(id string, commands string) func(params map[string]interface{}) bool {
+		return func(params map[string]interface{}) bool {
+			registrations, _ := params["registrations"].([]interface{})
+			for _, registration := range registrations {
+				registration, _ := registration.(map[string]interface{})
+				options, _ := registration["registerOptions"].(map[string]interface{})
+
+				if registration["id"] == commandRegistrationID(id) && fmt.Sprint(options["commands"]) == commands {
+					return true
+				}
+			}
+
+			return false
+		}
+	}
+
+	editor.WaitForNotification("client/registerCapability", registered("xml", "[shared.fix xml.validate]"))
+	close(json.Initialize)
+	json.WaitFor("initialized")
+	editor.WaitForNotification("client/registerCapability", registered("json", "[json.shared.fix]"))
+	editor.WaitForNotification("client/registerCapability", registered("xml", "[xml.shared.fix xml.validate]"))
+}
+
+func TestDocumentRequestsAreRegisteredForDeclaringBackends(t *testing.T) {
+	xml := NewFakeBackend(t, map[string]interface{}{
+		"textDocumentSync": 1,
+		"documentOnTypeFormattingProvider": map[string]interface{}{
+			"firstTriggerCharacter": ">",
+			"moreTriggerCharacter":  []string{"/"},
+		},
+	})
+	json := NewFakeBackend(t, map[string]interface{}{
+		"textDocumentSync": map[string]interface{}{"openClose": true, "change": 1, "willSaveWaitUntil": true},
+	})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json, "xml": xml})
+	response := editor.Request("initialize", map[string]interface{}{
+		"rootUri": "file:///project",
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"synchronization":  map[string]interface{}{"dynamicRegistration": true, "willSaveWaitUntil": true},
+				"onTypeFormatting": map[string]interface{}{"dynamicRegistration": true},
+			},
+		},
+	})
+
+	result, _ := response["result"].(map[string]interface{})
+	capabilities, _ := result["capabilities"].(map[string]interface{})
+	sync, _ := capabilities["textDocumentSync"].(map[string]interface{})
+
+	if _, ok := capabilities["documentOnTypeFormattingProvider"]; ok || sync["willSaveWaitUntil"] != nil {
+		t.Errorf("expected requests only some backends answer not to be advertised, got %v", capabilities)
+	}
+
+	// Backends are initialized in any order
+	expected := map[string]bool{
+		"[map[id:proxy-ls/textDocument/onTypeFormatting/xml method:textDocument/onTypeFormatting " +
+			"registerOptions:map[documentSelector:[map[pattern:**/*.{xml,doap}]] firstTriggerCharacter:> moreTriggerCharacter:[/]]]]": true,
+		"[map[id:proxy-ls/textDocument/willSaveWaitUntil/json method:textDocument/willSaveWaitUntil " +
+			"registerOptions:map[documentSelector:[map[pattern:**/*.json]]]]]": true,
+	}
+
+	for len(expected) > 0 {
+		editor.WaitForNotification("client/registerCapability", func(params map[string]interface{}) bool {
+			registrations := fmt.Sprint(params["registrations"])
+			if !expected[registrations] {
+				return false
+			}
+
+			delete(expected, registrations)
+
+			return true
+		})
+	}
+}
+
+func TestWatchedFilesAreRoutedToWatchingBackends(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+	ruff := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json, "ruff": ruff})
+	editor.Initialize()
+	json.WaitFor("initialized")
+	ruff.WaitFor("initialized")
+
+	response := ruff.Request("client/registerCapability", map[string]interface{}{
+		"registrations": []interface{}{
+			map[string]interface{}{
+				"id":     "watch",
+				"method": "workspace/didChangeWatchedFiles",
+				"registerOptions": map[string]interface{}{
+					"watchers": []interface{}{
+						map[string]interface{}{"globPattern": "**/{pyproject,ruff}.toml"},
+						map[string]interface{}{
+							"globPattern": map[string]interface{}{"baseUri": "file:///project", "pattern": "*.py"},
+							"kind":        4,
+						},
+					},
+				},
+			},
+		},
+	})
+	if response["error"] != nil {
+		t.Fatalf("registration failed: %v", response["error"])
+	}
+
+	editor.Notify("workspace/didChangeWatchedFiles", map[string]interface{}{
+		"changes": []interface{}{
+			map[string]interface{}{"uri": "file:///project/sub/pyproject.toml", "type": 2},
+			map[string]interface{}{"uri": "file:///project/a.py", "type": 2},
+			map[string]interface{}{"uri": "file:///project/b.py", "type": 3},
+			map[string]interface{}{"uri": "file:///project/c.json", "type": 1},
+		},
+	})
+
+	for _, message := range ruff.WaitFor("workspace/didChangeWatchedFiles") {
+		if message["method"] != "workspace/didChangeWatchedFiles" {
+			continue
+		}
+
+		params, _ := message["params"].(map[string]interface{})
+		if changes := fmt.Sprint(params["changes"]); changes != "[map[type:2 uri:file:///project/sub/pyproject.toml] map[type:3 uri:file:///project/b.py]]" {
+			t.Errorf("expected only the watched changes, got %v", changes)
+		}
+	}
+
+	ruff.Request("client/unregisterCapability", map[string]interface{}{
+		"unregisterations": []interface{}{map[string]interface{}{"id": "watch", "method": "workspace/didChangeWatchedFiles"}},
+	})
+
+	// json never registered a watcher, ruff no longer has one
+	editor.Notify("workspace/didChangeWatchedFiles", map[string]interface{}{
+		"changes": []interface{}{map[string]interface{}{"uri": "file:///project/ruff.toml", "type": 1}},
+	})
+	editor.Request("proxy/status", nil)
+
+	for _, backend := range []*FakeBackend{json, ruff} {
+		if count := len(filterMethod(backend.WaitFor("initialized"), "workspace/didChangeWatchedFiles")); count > 1 {
+			t.Errorf("expected changes only while a watcher is registered, got %d", count)
+		}
+	}
+}
+
+func TestWorkspaceIsWatchedWithInotify(t *testing.T) {
+	directory := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(directory, "data"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	xml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"xml": xml})
+	editor.Request("initialize", map[string]interface{}{
+		"rootUri":      "file://" + directory,
+		"capabilities": map[string]interface{}{},
+	})
+	xml.WaitFor("initialized")
+	xml.Request("client/registerCapability", map[string]interface{}{
+		"registrations": []interface{}{
+			map[string]interface{}{
+				"id":              "watch",
+				"method":          "workspace/didChangeWatchedFiles",
+				"registerOptions": map[string]interface{}{"watchers": []interface{}{map[string]interface{}{"globPattern": "**/*.xml"}}},
+			},
+		},
+	})
+
+	editor.server.mu.RLock()
+	watcher := editor.server.watcher
+	editor.server.mu.RUnlock()
+
+	select {
+	case <-watcher.walked:
+	case <-time.After(testTimeout):
+		t.Fatal("the workspace was never walked")
+	}
+
+	gschema := filepath.Join(directory, "data", "org.example.gschema.xml")
+	if err := os.WriteFile(gschema, []byte("<schemalist/>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, message := range xml.WaitFor("workspace/didChangeWatchedFiles") {
+		if message["method"] != "workspace/didChangeWatchedFiles" {
+			continue
+		}
+
+		params, _ := message["params"].(map[string]interface{})
+		if changes := fmt.Sprint(params["changes"]); !strings.Contains(changes, "uri:file://"+gschema) {
+			t.Errorf("expected the new GSchema file, got %v", changes)
+		}
+	}
+
+	// The new file is validated against the GSchema DTD
+	for count := 1; ; count++ {
+		messages := filterMethod(xml.WaitForCount("workspace/didChangeConfiguration", count), "workspace/didChangeConfiguration")
+		if strings.Contains(fmt.Sprint(messages[len(messages)-1]), gschema) {
+			break
+		}
+	}
+}
+
+func TestEditorProxySettingsAreApplied(t *testing.T) {
+	json := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1, "documentFormattingProvider": true})
+	yaml := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+	rome := NewFakeBackend(t, map[string]interface{}{"textDocumentSync": 1})
+
+	editor := newTestServer(t, map[string]*FakeBackend{"json": json, "yaml": yaml, "rome": rome})
+	editor.Request("initialize", map[string]interface{}{
+		"rootUri":      "file:///project",
+		"capabilities": map[string]interface{}{},
+		"initializationOptions": map[string]interface{}{
+			"proxyLs": map[string]interface{}{
+				"disabled":   []interface{}{"rome"},
+				"formatters": map[string]interface{}{"json": false},
+				"schemas":    map[string]interface{}{"https://example.com/ci.json": []interface{}{"ci/*.yaml"}},
+				"settings":   map[string]interface{}{"yaml": map[string]interface{}{"format": map[string]interface{}{"enable": false}}},
+			},
+		},
+	})
+	json.WaitFor("initialized")
+	yaml.WaitFor("initialized")
+
+	response := yaml.Request("workspace/configuration", map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"section": "yaml.format.enable"},
+			map[string]interface{}{"section": "yaml.schemas"},
+		},
+	})
+	if result := fmt.Sprint(response["result"]); !strings.HasPrefix(result, "[false ") || !strings.Contains(result, "https://example.com/ci.json:[ci/*.yaml]") {
+		t.Errorf("expected the settings of the editor, got %v", result)
+	}
+
+	editor.Open("file:///project/a.json", "json", "{}")
+
+	response = editor.Request("textDocument/formatting", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///project/a.json"},
+		"options":      map[string]interface{}{"tabSize": 2, "insertSpaces": true},
+	})
+	if response["error"] != nil || response["result"] != nil {
+		t.Errorf("expected no edits, got %v", response)
+	}
+
+	if count := len(filterMethod(json.WaitFor("textDocument/didOpen"), "textDocument/formatting")); count != 0 {
+		t.Errorf("expected the formatting request to be answered by proxy-ls")
+	}
+
+	status, _ := editor.Request("proxy/status", nil)["result"].(map[string]interface{})
+	if backends := fmt.Sprint(status["backends"]); !strings.Contains(backends, "id:rome") || !strings.Contains(backends, "state:"+BackendDisabled) {
+		t.Errorf("expected rome to be disabled, got %v", backends)
+	}
+
+	// Without the proxyLs object rome is enabled again
+	editor.Notify("workspace/didChangeConfiguration", map[string]interface{}{
+		"settings": map[string]interface{}{"proxyLs": map[string]interface{}{"logLevel": "debug"}},
+	})
+	rome.WaitFor("initialized")
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mergeSettings returns overrides merged into defaults. Objects are merged
+// key by key, anything else is replaced. Neither argument is modified.
+func mergeSettings(defaults interface{}, overrides interface{}) interface{} {
+	overrideObject, isObject := overrides.(map[string]interface{})
+	defaultObject, isDefaultObject := defaults.(map[string]interface{})
+
+	if !isObject || !isDefaultObject {
+		return overrides
+	}
+
+	merged := make(map[string]interface{}, len(defaultObject)+len(overrideObject))
+	for key, value := range defaultObject {
+		merged[key] = value
+	}
+
+	for key, value := range overrideObject {
+		merged[key] = mergeSettings(defaultObject[key], value)
+	}
+
+	return merged
+}
+
+// lookupSettings returns the value of a dotted section like
+// python.analysis in a settings tree.
+func lookupSettings(tree map[string]interface{}, section string) (interface{}, bool) {
+	if section == "" {
+		return tree, tree != nil
+	}
+
+	var value interface{} = tree
+
+	for _, key := range strings.Split(section, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// A settingsLayer is one source of settings. Scopes are settings for the
+// documents in a directory, relative to root unless absolute.
+type settingsLayer struct {
+	root     string
+	settings map[string]interface{}
+	scopes   map[string]map[string]interface{}
+}
+
+// matchingScopes returns the directories of the scopes applying to path,
+// outermost first.
+func (l settingsLayer) matchingScopes(path string) []string {
+	directories := make([]string, 0, len(l.scopes))
+
+	for directory := range l.scopes {
+		absolute := directory
+		if !filepath.IsAbs(absolute) {
+			if l.root == "" {
+				continue
+			}
+
+			absolute = filepath.Join(l.root, directory)
+		}
+
+		if path == absolute || strings.HasPrefix(path, absolute+"/") {
+			directories = append(directories, directory)
+		}
+	}
+
+	sort.Slice(directories, func(i, j int) bool {
+		return len(directories[i]) < len(directories[j])
+	})
+
+	return directories
+}
+
+// settingsLayersLocked returns the layers above the built-in settings, later
+// layers take precedence.
+func (s *Server) settingsLayersLocked() []settingsLayer {
+	return []settingsLayer{
+		{settings: s.options.Settings, scopes: s.options.Scopes},
+		{root: s.projectRoot, settings: s.project.Settings, scopes: s.project.Scopes},
+		{settings: s.editorConfig.Settings, scopes: s.editorConfig.Scopes},
+		{settings: s.editorSettings},
+	}
+}
+
+// settingsTreeLocked returns the settings of the document or directory scope,
+// or of the whole workspace if scope is empty. Scopes of a layer take
+// precedence over the rest of the layer, formatting over the built-in
+// settings only.
+func (s *Server) settingsTreeLocked(scope string, formatting map[string]interface{}) map[string]interface{} {
+	var tree interface{} = defaultSettings()
+
+	tree = mergeSettings(tree, map[string]interface{}{
+		"json": map[string]interface{}{"schemas": s.jsonSchemasLocked()},
+		"xml":  map[string]interface{}{"fileAssociations": s.xmlSchemasLocked()},
+		"yaml": map[string]interface{}{"schemas": s.yamlSchemasLocked()},
+	})
+
+	if formatting != nil {
+		tree = mergeSettings(tree, formatting)
+	}
+
+	path := uriPath(scope)
+
+	for _, layer := range s.settingsLayersLocked() {
+		if layer.settings != nil {
+			tree = mergeSettings(tree, layer.settings)
+		}
+
+		if path == "" {
+			continue
+		}
+
+		for _, directory := range layer.matchingScopes(path) {
+			tree = mergeSettings(tree, layer.scopes[directory])
+		}
+	}
+
+	merged, _ := tree.(map[string]interface{})
+
+	return merged
+}
+
+// section returns the settings of a dotted section for the document or
+// directory scope, nil if there are none.
+func (s *Server) section(section string, scope string) interface{} {
+	// Read before taking the lock, it looks for .editorconfig files
+	formatting := s.formattingSettings(scope)
+
+	s.mu.RLock()
+	tree := s.settingsTreeLocked(scope, formatting)
+	s.mu.RUnlock()
+
+	value, _ := lookupSettings(tree, section)
+
+	return value
+}
+
+// schemaAssociationsLocked returns the schemas of the user, project and editor
+// configuration with the glob patterns of the files they apply to.
+func (s *Server) schemaAssociationsLocked() map[string][]string {
+	schemas := make(map[string][]string, len(s.options.Schemas)+len(s.project.Schemas)+len(s.editorConfig.Schemas))
+
+	for _, layer := range []map[string][]string{s.options.Schemas, s.project.Schemas, s.editorConfig.Schemas} {
+		for schema, patterns := range layer {
+			schemas[schema] = patterns
+		}
+	}
+
+	return schemas
+}
+
+// jsonSchemasLocked returns the configured schemas in the format of the
+// json.schemas setting.
+func (s *Server) jsonSchemasLocked() []interface{} {
+	associations := s.schemaAssociationsLocked()
+	schemas := make([]interface{}, 0, len(associations))
+
+	for schema, patterns := range associations {
+		schemas = append(schemas, map[string]interface{}{
+			"url":       schema,
+			"fileMatch": patterns,
+		})
+	}
+
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].(map[string]interface{})["url"].(string) < schemas[j].(map[string]interface{})["url"].(string)
+	})
+
+	return schemas
+}
+
+// xmlSchemasLocked returns the DTDs of the GSchema and GResource files that
+// were opened.
+func (s *Server) xmlSchemasLocked() []interface{} {
+	schemas := make([]interface{}, 0, s.gschemaFiles.Size()+s.gresourceFiles.Size())
+
+	for _, gschema := range s.gschemaFiles.Slice() {
+		schemas = append(schemas, map[string]interface{}{
+			"pattern":  gschema,
+			"systemId": GSchemaDTD,
+		})
+	}
+
+	for _, gresource := range s.gresourceFiles.Slice() {
+		schemas = append(schemas, map[string]interface{}{
+			"pattern":  gresource,
+			"systemId": GResourceDTD,
+		})
+	}
+
+	return schemas
+}
+
+// yamlSchemasLocked returns the schemas of YAML files, keyed by schema.
+func (s *Server) yamlSchemasLocked() map[string]interface{} {
+	schemas := map[string]interface{}{
+		FlatpakManifestSchema: s.yamlFlatpakManifests.Slice(),
+	}
+
+	for schema, patterns := range s.schemaAssociationsLocked() {
+		schemas[schema] = patterns
+	}
+
+	return schemas
+}
+
+// settingsMessagesLocked returns the notifications sending a backend its
+// settings.
+func (s *Server) settingsMessagesLocked(id string) [][]byte {
+	tree := s.settingsTreeLocked("", nil)
+	calls := make([]map[string]interface{}, 0, 2)
+
+	switch id {
+	case "json":
+		calls = append(calls, makeNotification("json/schemaAssociations", []any{[]interface{}{
+			map[string]interface{}{
+				"uri":       FlatpakManifestSchema,
+				"fileMatch": s.flatpakManifests.Slice(),
+			},
+		}}))
+	case "yaml":
+		// yaml-language-server reads its settings from the top level
+		calls = append(calls, makeNotification("workspace/didChangeConfiguration", map[string]interface{}{
+			"yaml": tree["yaml"],
+		}))
+	}
+
+	if id != "yaml" {
+		settings := make(map[string]interface{}, len(backendSections[id]))
+		for _, section := range backendSections[id] {
+			if value, ok := lookupSettings(tree, section); ok {
+				settings[section] = value
+			}
+		}
+
+		calls = append(calls, makeNotification("workspace/didChangeConfiguration", map[string]interface{}{
+			"settings": settings,
+		}))
+	}
+
+	messages := make([][]byte, 0, len(calls))
+
+	for _, call := range calls {
+		data, _ := json.Marshal(call)
+		s.logger.Infof("(%v) %v: %s", id, call["method"], string(data))
+		messages = append(messages, data)
+	}
+
+	return messages
+}
+
+// pushSettings sends backends their current settings.
+func (s *Server) pushSettings(ids ...string) {
+	s.mu.RLock()
+	messages := make(map[string][][]byte, len(ids))
+
+	for _, id := range ids {
+		messages[id] = s.settingsMessagesLocked(id)
+	}
+	s.mu.RUnlock()
+
+	// Sent without holding the lock, as a full queue blocks until the backend
+	// has read its input
+	for _, id := range ids {
+		for _, data := range messages[id] {
+			if err := s.forward(id, data); err != nil {
+				s.logger.Warnf("Unable to update the configuration of %s: %s", id, err)
+
+				break
+			}
+		}
+	}
+}
+
+// updateConfigs sends the schemas of the files that were detected to the
+// backends validating them.
+func (s *Server) updateConfigs() {
+	s.pushSettings("json", "xml", "yaml", "toml")
+}
+
+// settingsSnapshot returns the settings each backend reads, with those of all
+// scopes, to find the backends affected by a change.
+func (s *Server) settingsSnapshot() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	trees := map[string]map[string]interface{}{"": s.settingsTreeLocked("", nil)}
+
+	for _, layer := range s.settingsLayersLocked() {
+		for directory, scoped := range layer.scopes {
+			trees[layer.root+"\x00"+directory] = scoped
+		}
+	}
+
+	snapshot := make(map[string]string, len(backendSections))
+
+	for id, sections := range backendSections {
+		values := make(map[string]interface{}, len(trees))
+
+		for key, tree := range trees {
+			for _, section := range sections {
+				if value, ok := lookupSettings(tree, section); ok {
+					values[key+"\x00"+section] = value
+				}
+			}
+		}
+
+		data, _ := json.Marshal(values)
+		snapshot[id] = string(data)
+	}
+
+	return snapshot
+}
+
+// notifySettingsChanged sends the backends whose settings changed since
+// before their new settings.
+func (s *Server) notifySettingsChanged(before map[string]string) {
+	after := s.settingsSnapshot()
+	changed := make([]string, 0, len(after))
+
+	for _, spec := range defaultBackends {
+		if before[spec.ID] != after[spec.ID] {
+			changed = append(changed, spec.ID)
+		}
+	}
+
+	if len(changed) > 0 {
+		s.logger.Infof("Settings of %s changed", strings.Join(changed, ", "))
+		s.pushSettings(changed...)
+	}
+}
+
+// changeConfiguration takes the settings the editor pushed as the topmost
+// layer.
+func (s *Server) changeConfiguration(request map[string]interface{}) error {
+	var params struct {
+		Settings interface{} `json:"settings"`
+	}
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &params); err != nil {
+		return fmt.Errorf("invalid workspace/didChangeConfiguration params: %w", err)
+	}
+
+	settings, _ := params.Settings.(map[string]interface{})
+	before := s.settingsSnapshot()
+	sync := false
+
+	// Settings without the proxyLs object leave the previous one in place
+	if value, ok := settings[ProxySettingsKey]; ok {
+		sync = s.setProxySettings(value)
+
+		sections := make(map[string]interface{}, len(settings))
+		for section, value := range settings {
+			sections[section] = value
+		}
+
+		delete(sections, ProxySettingsKey)
+		settings = sections
+	}
+
+	s.mu.Lock()
+	s.editorSettings = settings
+	s.mu.Unlock()
+
+	if sync {
+		if err := s.syncBackends(s.backendCommands()); err != nil {
+			return err
+		}
+	}
+
+	s.notifySettingsChanged(before)
+
+	return nil
+}
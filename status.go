@@ -0,0 +1,176 @@
+package main
+
+import (
+	"time"
+
+	"github.com/withmandala/go-log"
+)
+
+const (
+	StatusCommand         = "proxy.status"
+	RestartBackendCommand = "proxy.restartBackend"
+	ReloadConfigCommand   = "proxy.reloadConfig"
+)
+
+var proxyCommands = []string{StatusCommand, RestartBackendCommand, ReloadConfigCommand}
+
+type BackendStatus struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	State   string `json:"state"`
+	PID     int    `json:"pid,omitempty"`
+	// In seconds since the backend was started
+	Uptime          int64    `json:"uptime,omitempty"`
+	Restarts        int      `json:"restarts"`
+	PendingRequests int      `json:"pendingRequests"`
+	OpenDocuments   []string `json:"openDocuments"`
+	LastError       string   `json:"lastError,omitempty"`
+	// The names the commands of the backend are advertised under
+	Commands []string `json:"commands,omitempty"`
+}
+
+type Status struct {
+	Version  string          `json:"version"`
+	Backends []BackendStatus `json:"backends"`
+}
+
+// status reports the state of every backend, including disabled ones.
+func (s *Server) status() Status {
+	documents := make(map[string][]string, LanguageServerCount)
+
+	for _, document := range s.documents.All() {
+		if id, err := s.selectLSForFile(document.URI, "", true); err == nil {
+			documents[id] = append(documents[id], document.URI)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for uri := range s.virtualDocuments {
+		documents["bash"] = append(documents["bash"], uri)
+	}
+
+	pending := make(map[string]int, LanguageServerCount)
+	for _, request := range s.pendingRequests {
+		pending[request.backend]++
+	}
+
+	status := Status{Version: Version, Backends: make([]BackendStatus, 0, len(defaultBackends))}
+
+	for _, spec := range defaultBackends {
+		report := BackendStatus{
+			ID:              spec.ID,
+			Command:         s.backendCommandLocked(spec.ID),
+			State:           BackendDisabled,
+			PendingRequests: pending[spec.ID],
+			OpenDocuments:   documents[spec.ID],
+		}
+
+		if report.OpenDocuments == nil {
+			report.OpenDocuments = []string{}
+		}
+
+		if backend, ok := s.backends[spec.ID]; ok && !s.isDisabledLocked(spec.ID) {
+			report.Command = backend.Command
+			report.State = backend.state
+			report.Restarts = backend.restarts
+
+			if len(backend.commands) > 0 {
+				report.Commands = exposedCommands(backend)
+			}
+
+			if backend.lastErr != nil {
+				report.LastError = backend.lastErr.Error()
+			}
+
+			if backend.state != BackendCrashed {
+				report.Uptime = int64(time.Since(backend.started) / time.Second)
+
+				if process := backend.rpc.Process(); process != nil {
+					report.PID = process.PID()
+				}
+			}
+		}
+
+		status.Backends = append(status.Backends, report)
+	}
+
+	return status
+}
+
+// runProxyCommand runs one of proxyCommands.
+func (s *Server) runProxyCommand(command string, arguments []interface{}) (interface{}, error) {
+	switch command {
+	case StatusCommand:
+		return s.status(), nil
+	case RestartBackendCommand:
+		if len(arguments) != 1 {
+			return nil, newResponseError(InvalidParams, "%s expects the backend as its argument", command)
+		}
+
+		id, ok := arguments[0].(string)
+		if !ok {
+			return nil, newResponseError(InvalidParams, "%s expects the backend as its argument", command)
+		}
+
+		return nil, s.restartBackend(id)
+	case ReloadConfigCommand:
+		return nil, s.reloadConfig()
+	}
+
+	return nil, newResponseError(InvalidParams, "Unknown command %s", command)
+}
+
+// reloadConfig reads the user and project configuration again.
+func (s *Server) reloadConfig() error {
+	if s.options.Reload == nil {
+		return newResponseError(RequestFailed, "The configuration can't be reloaded")
+	}
+
+	options, err := s.options.Reload()
+	if err != nil {
+		return newResponseError(RequestFailed, "Unable to reload the configuration: %s", err)
+	}
+
+	commands := s.backendCommands()
+	before := s.settingsSnapshot()
+
+	s.mu.Lock()
+	s.options.Commands = options.Commands
+	s.options.Disabled = options.Disabled
+	s.options.BackendLogLevels = options.BackendLogLevels
+	s.options.MessageLevels = options.MessageLevels
+	s.options.Settings = options.Settings
+	s.options.Schemas = options.Schemas
+	s.options.Scopes = options.Scopes
+	s.options.Formatters = options.Formatters
+	// A level the editor chose takes precedence over the configuration
+	levelChanged := s.options.LogLevel != options.LogLevel && s.editorConfig.LogLevel == ""
+	s.options.LogLevel = options.LogLevel
+	writer := s.options.LogLevelWriter
+	s.backendLoggers = make(map[string]*log.Logger, LanguageServerCount)
+	initialized := s.initialization != nil
+	s.mu.Unlock()
+
+	if levelChanged && writer != nil {
+		writer.setLevel(s.logger, options.LogLevel)
+		s.logger.Infof("Log level set to %s by the configuration", logLevelName(options.LogLevel))
+	}
+
+	s.logger.Infof("Reloaded the configuration")
+
+	if initialized {
+		s.loadProject()
+	}
+
+	if err := s.syncBackends(commands); err != nil {
+		return err
+	}
+
+	if initialized {
+		s.notifySettingsChanged(before)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// A fanOut collects the results of a request sent to several backends.
+type fanOut struct {
+	mu        sync.Mutex
+	seq       interface{}
+	remaining int
+	limit     int
+	results   []interface{}
+	// Results are sent as $/progress with this token as they arrive
+	partialResultToken interface{}
+}
+
+// collect adds the result of one backend. Once all backends answered, the
+// merged results are sent to the editor.
+func (s *Server) collect(f *fanOut, result interface{}) {
+	items, _ := result.([]interface{})
+
+	// Sent under the lock, so partial results can't overtake the response
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	room := f.limit - len(f.results)
+	if room < 0 {
+		room = 0
+	}
+
+	if len(items) > room {
+		items = items[:room]
+	}
+
+	f.results = append(f.results, items...)
+	f.remaining--
+
+	if f.partialResultToken != nil && len(items) > 0 {
+		err := s.sendToEditor(makeNotification("$/progress", map[string]interface{}{
+			"token": f.partialResultToken,
+			"value": items,
+		}))
+		if err != nil {
+			s.logger.Warnf("Unable to send partial results: %s", err)
+		}
+	}
+
+	if f.remaining > 0 {
+		return
+	}
+
+	results := f.results
+	if f.partialResultToken != nil {
+		results = []interface{}{} // Everything was reported as partial results
+	}
+
+	if err := s.sendToEditor(makeResponse(f.seq, results)); err != nil {
+		s.logger.Warnf("Unable to send merged results: %s", err)
+	}
+}
+
+// workspaceSymbols sends workspace/symbol to every ready backend providing
+// workspace symbols and merges their results.
+func (s *Server) workspaceSymbols(request map[string]interface{}) error {
+	var params map[string]interface{}
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &params); err != nil {
+		return newResponseError(InvalidParams, "Invalid workspace/symbol params: %s", err)
+	}
+
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.backends))
+
+	for id, backend := range s.backends {
+		if backend.state == BackendReady && backend.workspaceSymbols {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(ids)
+
+	if len(ids) == 0 {
+		return s.sendToEditor(makeResponse(request["id"], []interface{}{}))
+	}
+
+	// Backends answer in full, proxy-ls streams their results instead
+	f := &fanOut{
+		seq:                request["id"],
+		remaining:          len(ids),
+		limit:              MaxWorkspaceSymbols,
+		partialResultToken: params["partialResultToken"],
+	}
+	delete(params, "partialResultToken")
+
+	for _, id := range ids {
+		err := s.redirectPending(id, makeRequest(request["id"], "workspace/symbol", params), &pendingRequest{fanOut: f})
+		if err != nil {
+			s.logger.Warnf("(%v) Unable to send workspace/symbol: %s", id, err)
+			s.collect(f, nil)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	TraceReceived = "received"
+	TraceSent     = "sent"
+	// The connection name of the editor, the other connections are named after their backend
+	EditorConnection = "editor"
+)
+
+// A TraceEntry is one line of a trace file. The direction is seen from proxy-ls.
+type TraceEntry struct {
+	Timestamp  time.Time       `json:"timestamp"`
+	Direction  string          `json:"direction"`
+	Connection string          `json:"connection"`
+	Message    json.RawMessage `json:"message"`
+}
+
+// Tracer writes every message on every connection to a JSONL file.
+// A nil Tracer records nothing.
+type Tracer struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewTracer(path string) (*Tracer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("NewTracer(): %w", err)
+	}
+
+	return &Tracer{file: file}, nil
+}
+
+func (t *Tracer) Record(direction string, connection string, message []byte) {
+	if t == nil {
+		return
+	}
+
+	entry := TraceEntry{
+		Timestamp:  time.Now(),
+		Direction:  direction,
+		Connection: connection,
+		Message:    message,
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		// Not valid JSON, keep it as a string
+		entry.Message, _ = json.Marshal(string(message))
+		data, _ = json.Marshal(entry)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, _ = t.file.Write(append(data, '\n'))
+}
+
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.file.Close()
+}
+
+func ReadTrace(path string) ([]TraceEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadTrace(): %w", err)
+	}
+	defer file.Close()
+
+	entries := make([]TraceEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, ReadBufferSize), DefaultMaxMessageSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("ReadTrace(): %s:%d: %w", path, line, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ReadTrace(): %w", err)
+	}
+
+	return entries, nil
+}
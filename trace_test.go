@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTraceIsWrittenAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+
+	tracer, err := NewTracer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer.Record(TraceReceived, EditorConnection, []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`))
+	tracer.Record(TraceSent, "json", []byte(`{"jsonrpc":`))
+
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadTrace(path)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected two entries, got %v (%v)", entries, err)
+	}
+
+	if entries[0].Direction != TraceReceived || entries[0].Connection != EditorConnection || string(entries[0].Message) != `{"jsonrpc":"2.0","id":1,"method":"initialize"}` {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+
+	// Invalid JSON is kept as a string
+	if entries[1].Direction != TraceSent || entries[1].Connection != "json" || string(entries[1].Message) != `"{\"jsonrpc\":"` {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+
+	if entries[1].Timestamp.Before(entries[0].Timestamp) {
+		t.Errorf("expected entries in the order they were recorded")
+	}
+}
+
+func TestNilTracerRecordsNothing(t *testing.T) {
+	var tracer *Tracer
+
+	tracer.Record(TraceSent, EditorConnection, []byte("{}"))
+
+	if err := tracer.Close(); err != nil {
+		t.Errorf("expected closing a nil tracer to succeed, got %s", err)
+	}
+}
+
+func TestReadTraceReportsTheLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	if err := os.WriteFile(path, []byte("{}\n\nnot json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadTrace(path); err == nil || !strings.Contains(err.Error(), path+":3") {
+		t.Errorf("expected an error on line 3, got %v", err)
+	}
+
+	if _, err := ReadTrace(filepath.Join(t.TempDir(), "missing.jsonl")); err == nil {
+		t.Errorf("expected an error for a missing trace")
+	}
+}
@@ -1,22 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
 	"syscall"
 )
 
-func checkerror(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
-
-func checkok(ok bool) {
-	if !ok {
-		panic("")
-	}
-}
-
 type SyscallWriteCloser struct {
 	fd int
 }
@@ -29,35 +18,41 @@ func (p *SyscallWriteCloser) Close() error {
 	return syscall.Close(p.fd)
 }
 
-func ExtractIntValue(idValue interface{}) int {
+func ExtractIntValue(idValue interface{}) (int, error) {
 	switch value := idValue.(type) {
 	case float64:
-		return int(value)
+		return int(value), nil
 	case string:
 		r, err := strconv.Atoi(value)
-		checkerror(err)
+		if err != nil {
+			return 0, fmt.Errorf("ExtractIntValue(): non-numeric id %q: %w", value, err)
+		}
 
-		return r
+		return r, nil
 	case int:
-		return value
-	default:
-		panic(value)
+		return value, nil
 	}
+
+	return 0, fmt.Errorf("ExtractIntValue(): invalid id %v", idValue)
 }
 
-func str2int(id string) int {
+func str2int(id string) (int, error) {
 	switch id {
 	case "yaml":
-		return YamlID
+		return YamlID, nil
 	case "json":
-		return JSONID
+		return JSONID, nil
 	case "xml":
-		return XMLID
+		return XMLID, nil
 	case "ruff":
-		return RUFFID
+		return RUFFID, nil
 	case "rome":
-		return ROMEID
+		return ROMEID, nil
+	case "toml":
+		return TOMLID, nil
+	case "bash":
+		return BASHID, nil
 	}
 
-	panic(id)
+	return 0, fmt.Errorf("str2int(): unknown language server %s", id)
 }
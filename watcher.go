@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-set"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+// The editor watches files for proxy-ls if it supports registering watchers,
+// otherwise proxy-ls watches the workspace folders with inotify. Either way,
+// backends get the changes of the files they registered watchers for.
+
+const (
+	WatchedFilesRegistrationID = "proxy-ls/didChangeWatchedFiles"
+	// The watch kind of watchers that don't specify it: create, change and delete
+	DefaultWatchKind = 7
+)
+
+type fileWatcher struct {
+	pattern *regexp.Regexp
+	kind    int
+}
+
+func (w fileWatcher) matches(change protocol.FileEvent) bool {
+	path := uriPath(change.URI)
+
+	return w.kind&(1<<(change.Type-1)) != 0 && path != "" && w.pattern.MatchString(path)
+}
+
+// watcherGlob returns the glob of a watcher as absolute path, resolving
+// relative patterns against their base.
+func watcherGlob(pattern interface{}) string {
+	switch pattern := pattern.(type) {
+	case string:
+		return pattern
+	case map[string]interface{}:
+		base := pattern["baseUri"]
+		if folder, ok := base.(map[string]interface{}); ok {
+			base = folder["uri"]
+		}
+
+		baseURI, _ := base.(string)
+		relative, _ := pattern["pattern"].(string)
+
+		if path := uriPath(baseURI); path != "" && relative != "" {
+			return strings.TrimSuffix(path, "/") + "/" + relative
+		}
+	}
+
+	return ""
+}
+
+// registerWatchers records the files a backend wants to be told about.
+// Registrations of other capabilities are accepted and ignored.
+func (s *Server) registerWatchers(id string, params interface{}) error {
+	var registrations struct {
+		Registrations []struct {
+			ID              string `json:"id"`
+			Method          string `json:"method"`
+			RegisterOptions struct {
+				Watchers []struct {
+					GlobPattern interface{} `json:"globPattern"`
+					Kind        *int        `json:"kind"`
+				} `json:"watchers"`
+			} `json:"registerOptions"`
+		} `json:"registrations"`
+	}
+
+	marshalledParams, _ := json.Marshal(params)
+	if err := json.Unmarshal(marshalledParams, &registrations); err != nil {
+		return newResponseError(InvalidParams, "Invalid client/registerCapability params: %s", err)
+	}
+
+	for _, registration := range registrations.Registrations {
+		if registration.Method != "workspace/didChangeWatchedFiles" {
+			continue
+		}
+
+		watchers := make([]fileWatcher, 0, len(registration.RegisterOptions.Watchers))
+
+		for _, watcher := range registration.RegisterOptions.Watchers {
+			glob := watcherGlob(watcher.GlobPattern)
+
+			pattern, err := compileGlob(glob)
+			if glob == "" || err != nil {
+				s.logger.Warnf("(%v) Ignoring watcher for %v", id, watcher.GlobPattern)
+
+				continue
+			}
+
+			kind := DefaultWatchKind
+			if watcher.Kind != nil {
+				kind = *watcher.Kind
+			}
+
+			watchers = append(watchers, fileWatcher{pattern: pattern, kind: kind})
+		}
+
+		s.mu.Lock()
+		if backend, ok := s.backends[id]; ok {
+			if backend.watchers == nil {
+				backend.watchers = make(map[string][]fileWatcher)
+			}
+
+			backend.watchers[registration.ID] = watchers
+		}
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// unregisterWatchers forgets watchers of a backend.
+func (s *Server) unregisterWatchers(id string, params interface{}) error {
+	var unregistrations struct {
+		// Sic, the specification misspells it
+		Unregistrations []struct {
+			ID string `json:"id"`
+		} `json:"unregisterations"`
+	}
+
+	marshalledParams, _ := json.Marshal(params)
+	if err := json.Unmarshal(marshalledParams, &unregistrations); err != nil {
+		return newResponseError(InvalidParams, "Invalid client/unregisterCapability params: %s", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if backend, ok := s.backends[id]; ok {
+		for _, unregistration := range unregistrations.Unregistrations {
+			delete(backend.watchers, unregistration.ID)
+		}
+	}
+
+	return nil
+}
+
+// watchFiles has the editor watch the workspace for proxy-ls, or else watches
+// the workspace folders itself, replacing the previous watcher.
+func (s *Server) watchFiles() {
+	s.mu.Lock()
+	editorWatches := s.editorWatchesFiles
+	previous := s.watcher
+	s.watcher = nil
+	folders := s.currentWorkspaceFoldersLocked()
+	s.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+
+	if editorWatches {
+		return
+	}
+
+	roots := make([]string, 0, len(folders))
+
+	for _, folder := range folders {
+		if path := uriPath(folder.URI); path != "" {
+			roots = append(roots, path)
+		}
+	}
+
+	if len(roots) == 0 {
+		return
+	}
+
+	watcher, err := newInotifyWatcher(roots, s.logger, s.filesChanged)
+	if err != nil {
+		s.logger.Warnf("Unable to watch the workspace: %s", err)
+
+		return
+	}
+
+	s.mu.Lock()
+	s.watcher = watcher
+	s.mu.Unlock()
+}
+
+// registerEditorWatcher asks the editor to report changes of all files.
+func (s *Server) registerEditorWatcher() {
+	params := map[string]interface{}{
+		"registrations": []interface{}{
+			map[string]interface{}{
+				"id":     WatchedFilesRegistrationID,
+				"method": "workspace/didChangeWatchedFiles",
+				"registerOptions": map[string]interface{}{
+					"watchers": []interface{}{map[string]interface{}{"globPattern": "**/*"}},
+				},
+			},
+		},
+	}
+
+	err := s.requestEditor("client/registerCapability", params, func(_ interface{}, err interface{}) {
+		if err != nil {
+			s.logger.Warnf("Unable to register a file watcher, watching the workspace: %v", err)
+
+			s.mu.Lock()
+			s.editorWatchesFiles = false
+			s.mu.Unlock()
+
+			s.watchFiles()
+		}
+	})
+	if err != nil {
+		s.logger.Warnf("Unable to register a file watcher: %s", err)
+	}
+}
+
+// stopWatching stops the inotify watcher, if there is one.
+func (s *Server) stopWatching() {
+	s.mu.Lock()
+	watcher := s.watcher
+	s.watcher = nil
+	s.mu.Unlock()
+
+	if watcher != nil {
+		watcher.Close()
+	}
+}
+
+// changeWatchedFiles handles the changes reported by the editor.
+func (s *Server) changeWatchedFiles(request map[string]interface{}) error {
+	var params protocol.DidChangeWatchedFilesParams
+
+	marshalledParams, _ := json.Marshal(request["params"])
+	if err := json.Unmarshal(marshalledParams, &params); err != nil {
+		return fmt.Errorf("invalid workspace/didChangeWatchedFiles params: %w", err)
+	}
+
+	s.filesChanged(params.Changes)
+
+	return nil
+}
+
+// filesChanged sends every backend the changes its watchers match and updates
+// what proxy-ls knows about the changed files.
+func (s *Server) filesChanged(changes []protocol.FileEvent) {
+	s.mu.RLock()
+	matched := make(map[string][]protocol.FileEvent, len(s.backends))
+
+	for id, backend := range s.backends {
+		for _, change := range changes {
+			for _, watchers := range backend.watchers {
+				if matchesAny(watchers, change) {
+					matched[id] = append(matched[id], change)
+
+					break
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	ids := make([]string, 0, len(matched))
+	for id := range matched {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		notification := makeNotification("workspace/didChangeWatchedFiles", protocol.DidChangeWatchedFilesParams{
+			Changes: matched[id],
+		})
+		if err := s.redirectNotification(id, notification); err != nil {
+			s.logger.Warnf("Unable to forward file changes to %s: %s", id, err)
+		}
+	}
+
+	s.trackChangedFiles(changes)
+}
+
+func matchesAny(watchers []fileWatcher, change protocol.FileEvent) bool {
+	for _, watcher := range watchers {
+		if watcher.matches(change) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trackChangedFiles updates the manifests, GSchema and GResource files that
+// were found, and reloads the project configuration if it changed.
+func (s *Server) trackChangedFiles(changes []protocol.FileEvent) {
+	updated, projectChanged := false, false
+
+	s.mu.RLock()
+	projectRoot := s.projectRoot
+	s.mu.RUnlock()
+
+	for _, change := range changes {
+		path := uriPath(change.URI)
+		if path == "" {
+			continue
+		}
+
+		// Manifests are known by the name in their URI, like in selectLSForFile
+		name := filepath.Base(change.URI)
+		exists := change.Type != protocol.FileChangeTypeDeleted
+
+		for _, configName := range ProjectConfigNames {
+			if name == configName && filepath.Dir(path) == filepath.Clean(projectRoot) {
+				projectChanged = true
+			}
+		}
+
+		switch {
+		case strings.HasSuffix(name, ".gschema.xml"):
+			updated = s.trackFile(s.gschemaFiles, path, exists) || updated
+		case strings.HasSuffix(name, ".gresource.xml"):
+			updated = s.trackFile(s.gresourceFiles, path, exists) || updated
+		case strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml"):
+			updated = s.trackFile(s.yamlFlatpakManifests, name, exists && fileMatches(path, isYamlFlatpakManifest)) || updated
+		case strings.HasSuffix(name, ".json"):
+			updated = s.trackFile(s.flatpakManifests, name, exists && fileMatches(path, isJSONFlatpakManifest)) || updated
+		}
+	}
+
+	if updated {
+		s.updateConfigs()
+	}
+
+	if projectChanged {
+		before := s.settingsSnapshot()
+
+		s.loadProject()
+
+		if err := s.syncBackends(s.backendCommands()); err != nil {
+			s.logger.Warnf("Unable to apply the project configuration: %s", err)
+		}
+
+		s.notifySettingsChanged(before)
+	}
+}
+
+// trackFile adds a file to or removes it from files, and returns whether that
+// changed anything.
+func (s *Server) trackFile(files *set.Set[string], file string, tracked bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tracked {
+		return files.Insert(file)
+	}
+
+	return files.Remove(file)
+}
+
+func fileMatches(path string, matches func(contents string) bool) bool {
+	contents, err := os.ReadFile(path)
+
+	return err == nil && matches(string(contents))
+}